@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0x656b694d/hop/data"
+)
+
+// accessLog writes one line per handled request, in JSON or in the Apache
+// combined log format.
+type accessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	sample float64
+}
+
+// accessEntry is a line of the JSON access log.
+type accessEntry struct {
+	Time       string   `json:"time"`
+	Remote     string   `json:"remote"`
+	Method     string   `json:"method"`
+	Path       string   `json:"path"`
+	Commands   []string `json:"commands"`
+	Code       int      `json:"code"`
+	Bytes      int      `json:"bytes"`
+	Duration   float64  `json:"duration_ms"`
+	Downstream []string `json:"downstream"`
+	TraceID    string   `json:"trace-id,omitempty"`
+}
+
+// access is nil when the access log is disabled.
+var access *accessLog
+
+// openAccessLog opens the access log destination: "-" or "stdout", "stderr",
+// or a file to append to.
+func openAccessLog(dest, format string, sample float64) (*accessLog, error) {
+	var w io.Writer
+	switch dest {
+	case "":
+		return nil, nil
+	case "-", "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return newAccessLog(w, format, sample)
+}
+
+func newAccessLog(w io.Writer, format string, sample float64) (*accessLog, error) {
+	if format != "json" && format != "combined" {
+		return nil, fmt.Errorf("unknown access log format: %s", format)
+	}
+	if sample < 0 || sample > 1 {
+		return nil, fmt.Errorf("access log sample rate %v is not within [0, 1]", sample)
+	}
+	return &accessLog{w: w, format: format, sample: sample}, nil
+}
+
+// log writes the line of the request, unless it is not sampled.
+func (a *accessLog) log(req *http.Request, r *data.RequestLog, bytes int) {
+	if a == nil || (a.sample < 1 && rand.Float64() >= a.sample) {
+		return
+	}
+	var line []byte
+	if a.format == "combined" {
+		line = []byte(combinedLine(req, r, bytes))
+	} else {
+		line, _ = json.Marshal(newAccessEntry(r, bytes))
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.w.Write(append(line, '\n'))
+}
+
+func newAccessEntry(r *data.RequestLog, bytes int) *accessEntry {
+	e := &accessEntry{
+		Time:       r.Start.UTC().Format(time.RFC3339Nano),
+		Remote:     r.From,
+		Method:     r.Method,
+		Path:       r.Path,
+		Commands:   []string{},
+		Code:       r.Code,
+		Bytes:      bytes,
+		Duration:   float64(r.Duration.Microseconds()) / 1000,
+		Downstream: []string{},
+		TraceID:    r.TraceID,
+	}
+	for _, clog := range r.Process {
+		if strings.HasPrefix(clog.Command, "-") {
+			e.Commands = append(e.Commands, clog.Command)
+		}
+		if clog.Url != "" {
+			e.Downstream = append(e.Downstream, clog.Url)
+		}
+	}
+	return e
+}
+
+// combinedLine formats the request in the Apache combined log format:
+// host ident user [time] "request" status bytes "referer" "user-agent".
+func combinedLine(req *http.Request, r *data.RequestLog, bytes int) string {
+	host, _, err := net.SplitHostPort(r.From)
+	if err != nil {
+		host = r.From
+	}
+	size := "-"
+	if bytes > 0 {
+		size = fmt.Sprint(bytes)
+	}
+	return fmt.Sprintf("%s - - [%s] %q %d %s %q %q",
+		dash(host),
+		r.Start.Format("02/Jan/2006:15:04:05 -0700"),
+		fmt.Sprintf("%s %s %s", r.Method, redactProxyArgs(req.URL.RequestURI()), req.Proto),
+		r.Code, size,
+		dash(req.Referer()), dash(req.UserAgent()))
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
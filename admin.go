@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// startAdminServer serves the operational endpoints on a dedicated port, so
+// that they don't go through the command interpreter.
+func (cfg *config) startAdminServer(quit chan<- int) *http.Server {
+	if cfg.port_admin == 0 {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	mux.HandleFunc("/healthz", healthz)
+	mux.Handle("/readyz", readiness)
+	mux.Handle("/livez", liveness)
+	mux.HandleFunc("/history", serveHistory)
+	mux.HandleFunc("/events", serveEvents)
+	mux.HandleFunc("/stats", serveStats)
+	mux.HandleFunc("/loglevel", serveLogLevel)
+	mux.HandleFunc("/rules", serveRules)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	s := getServer(cfg.localhost, uint16(cfg.port_admin))
+	s.Handler = mux
+
+	go func() {
+		log.Info("Serving admin endpoints on ", cfg.localhost, ":", cfg.port_admin)
+		log.Info(s.ListenAndServe())
+		quit <- 5
+	}()
+
+	return s
+}
@@ -2,46 +2,55 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/0x656b694d/hop/data"
+	"github.com/0x656b694d/hop/proxyproto"
 	"github.com/0x656b694d/hop/tlstools"
 	"github.com/0x656b694d/hop/tools"
+	"github.com/0x656b694d/hop/tracing"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
 )
 
-func proxy(req *http.Request) (*url.URL, error) {
-	switch req.URL.Scheme {
-	case "http":
-		return http_proxy_url, nil
-	case "https":
-		return https_proxy_url, nil
-	}
-	return http.ProxyFromEnvironment(req)
-}
+// protocols are the values of the -proto command.
+var protocols = map[string]struct{}{"h1": {}, "h2": {}, "h2c": {}}
 
 type hopClient struct {
 	http.Client
 	cfg *config
+
+	// transports force the protocol of the -proto command.
+	transports map[string]http.RoundTripper
 }
 
 func (cfg *config) getClient(roots *x509.CertPool) (*hopClient, error) {
 	transport := &http.Transport{
-		MaxIdleConns:        10,
-		IdleConnTimeout:     10 * time.Minute,
-		TLSHandshakeTimeout: 10 * time.Minute,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       10 * time.Minute,
+		TLSHandshakeTimeout:   10 * time.Minute,
+		ExpectContinueTimeout: time.Second,
+		Proxy:                 cfg.proxy,
+		DialContext: dialContext(&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}),
 		TLSClientConfig: &tls.Config{
 			RootCAs:            roots,
 			InsecureSkipVerify: cfg.insecure,
+			NextProtos:         []string{"http/1.1"},
 		},
 	}
 	if cfg.mtls {
@@ -56,25 +65,137 @@ func (cfg *config) getClient(roots *x509.CertPool) (*hopClient, error) {
 			transport.TLSClientConfig.Certificates = []tls.Certificate{*cfg.getCert("Hop client")}
 		}
 	}
-	if cfg.proxy_tunneling {
-		transport.Proxy = proxy
+
+	return &hopClient{http.Client{Transport: transport}, cfg, protoTransports(transport)}, nil
+}
+
+// protoTransports makes the transports of the -proto command from the
+// default one, which speaks HTTP/1.1 unless -alpn offers h2.
+func protoTransports(transport *http.Transport) map[string]http.RoundTripper {
+	transports := make(map[string]http.RoundTripper, len(protocols))
+	for proto := range protocols {
+		transports[proto] = protoTransport(transport, proto)
 	}
+	return transports
+}
 
-	return &hopClient{http.Client{Transport: transport}, cfg}, nil
+// protoTransport makes the transport of the protocol from the default one,
+// or returns the default one for an unknown protocol.
+func protoTransport(transport *http.Transport, proto string) http.RoundTripper {
+	switch proto {
+	case "h1":
+		h1 := transport.Clone()
+		h1.ForceAttemptHTTP2 = false
+		h1.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		h1.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		return h1
+	case "h2":
+		return &http2.Transport{
+			TLSClientConfig: transport.TLSClientConfig.Clone(),
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				conn, err := transport.DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				tlsConn := tls.Client(conn, cfg)
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return tlsConn, nil
+			},
+			IdleConnTimeout: transport.IdleConnTimeout,
+		}
+	case "h2c":
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return transport.DialContext(ctx, network, addr)
+			},
+			IdleConnTimeout: transport.IdleConnTimeout,
+		}
+	}
+	return transport
 }
 
-func (c *hopClient) callURL(req *http.Request, rtrip bool) (*http.Response, error) {
+// newTransport makes a transport without idle connections, for the requests
+// which need a new connection, with the TLS settings if given. The HTTP/2
+// transports keep the connection alive anyway, so the caller closes it with
+// closeIdleBody.
+func (c *hopClient) newTransport(proto string, tp *tlsParams) http.RoundTripper {
+	t, ok := c.Transport.(*http.Transport)
+	if !ok {
+		return c.Transport
+	}
+	t = t.Clone()
+	t.DisableKeepAlives = true
+	if tp != nil {
+		t.TLSClientConfig = tp.apply(t.TLSClientConfig)
+		t.ForceAttemptHTTP2 = tp.offersHTTP2()
+	}
+	return protoTransport(t, proto)
+}
+
+// closeIdleBody closes the idle connections of the client once the response
+// body is closed.
+type closeIdleBody struct {
+	io.ReadCloser
+	client *http.Client
+}
+
+func (b *closeIdleBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.client.CloseIdleConnections()
+	return err
+}
+
+func (c *hopClient) tlsConfig() *tls.Config {
+	if t, ok := c.Transport.(*http.Transport); ok {
+		return t.TLSClientConfig
+	}
+	return &tls.Config{}
+}
+
+func (c *hopClient) callURL(req *http.Request, rtrip bool, proto string) (*http.Response, error) {
 	if c.cfg.verbose {
-		if dump, err := httputil.DumpRequest(req, req.ContentLength < 1024); err == nil {
+		if dump, err := dumpRequest(req, req.ContentLength < 1024); err == nil {
 			log.Debug(string(dump))
 		} else {
 			log.Error(err)
 		}
 	}
+	start := time.Now()
+	var res *http.Response
+	var err error
+	client := c.Client
+	if t, ok := c.transports[proto]; ok {
+		client.Transport = t
+	}
+	tp, _ := req.Context().Value(tlsKey{}).(*tlsParams)
+	newConn := req.Context().Value(proxyHeaderKey{}) != nil || tp != nil
+	if newConn {
+		client.Transport = c.newTransport(proto, tp)
+	}
 	if rtrip {
-		return c.Client.Transport.RoundTrip(req)
+		res, err = client.Transport.RoundTrip(req)
+	} else {
+		res, err = client.Do(req)
 	}
-	return c.Do(req)
+	if newConn {
+		if err == nil {
+			res.Body = &closeIdleBody{res.Body, &client}
+		} else {
+			client.CloseIdleConnections()
+		}
+	}
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+	target := targets.label(req.URL.Host)
+	downstreamTotal.Inc(target, code)
+	downstreamDuration.Observe(time.Since(start).Seconds(), target)
+	return res, err
 }
 
 type reqParams struct {
@@ -86,6 +207,15 @@ type reqParams struct {
 	tlsInfo     bool
 	method      string
 	rtrip       bool
+	proto       string
+	ws          *wsParams
+	socket      string
+	proxyHeader *proxyproto.Header
+	proxy       *url.URL
+	noProxy     bool
+	tls         *tlsParams
+	trace       bool
+	span        *tracing.Span
 	headers     map[string]string
 	fheaders    []string
 	rheaders    map[string]string
@@ -98,15 +228,13 @@ func newReqParams() *reqParams {
 			"Accept-Encoding": "text/plain",
 			"User-Agent":      "hop",
 		},
-		rheaders: map[string]string{
-			"Content-type": "text/plain",
-		},
+		rheaders: map[string]string{},
 		fheaders: []string{},
 	}
 }
 
 func BuildRequest(url *url.URL, method string, headers map[string]string, size int) (*http.Request, error) {
-	log.Infof("Call %s, sending %d bytes and %v", url, size, headers)
+	log.Infof("Call %s, sending %d bytes and %v", redactProxyArgs(url.String()), size, headers)
 	payload := bytes.Repeat([]byte{'X'}, size)
 
 	if method == "" {
@@ -127,47 +255,119 @@ func BuildRequest(url *url.URL, method string, headers map[string]string, size i
 	return req, err
 }
 
+// startClientSpan starts the span of the call to the next hop and injects its
+// context in the headers. The returned function ends the span with the
+// outcome of the call.
+func startClientSpan(parent *tracing.Span, clog *data.CommandLog, method, host string, header http.Header) func() {
+	span := tracing.Start(method, tracing.KindClient, parent.Context)
+	span.SetAttribute("http.request.method", method)
+	span.SetAttribute("url.full", clog.Url)
+	span.SetAttribute("server.address", host)
+	span.Context.Inject(header)
+	return func() {
+		if clog.Code != 0 {
+			span.SetAttribute("http.response.status_code", int(clog.Code))
+		}
+		if clog.Error != "" {
+			span.SetError(clog.Error)
+		}
+		span.Finish()
+	}
+}
+
 func (handler *hopHandler) hop(params *reqParams) *data.CommandLog {
+	start := time.Now()
 	clog := &data.CommandLog{Command: "hop"}
+	defer func() {
+		clog.Duration = time.Since(start)
+	}()
 	r := &clog.Output
+	if params.ws != nil {
+		handler.hopWS(clog, params)
+		return clog
+	}
 	u := params.url
 	clientReq, err := BuildRequest(u, params.method, params.headers, params.size)
 	if err != nil {
-		r.Appendf("Couldn't make %s: %s\n", u, err.Error())
+		r.Appendf("Couldn't make %s: %s\n", redactProxyArgs(u.String()), err.Error())
 		return clog
 	} else if clientReq == nil {
-		r.Appendf("Couldn't make %s by some reason\n", u)
+		r.Appendf("Couldn't make %s by some reason\n", redactProxyArgs(u.String()))
 		return clog
 	}
-	if proxy_url, _ := proxy(clientReq); proxy_url != nil {
+	if params.proxyHeader != nil {
+		// The header is sent on a new connection, closed after the request.
+		clientReq = clientReq.WithContext(context.WithValue(clientReq.Context(), proxyHeaderKey{}, params.proxyHeader))
+		clientReq.Close = true
+	}
+	if params.tls != nil {
+		clientReq = withTLS(clientReq, params.tls)
+	}
+	switch {
+	case params.socket != "":
+		clientReq = withSocket(clientReq, params.socket)
+	case params.noProxy:
+		clientReq = withProxy(clientReq, nil)
+	case params.proxy != nil:
+		clientReq = withProxy(clientReq, params.proxy)
+	}
+	if proxy_url, source := logProxy(clog, clientReq); proxy_url != nil {
 		if handler.cfg.verbose {
-			log.Infof("Using proxy: %s", proxy_url)
+			log.Infof("Using proxy: %s", proxy_url.Redacted())
 		}
-		if !handler.cfg.proxy_tunneling {
+		if source != "-proxy" && !handler.cfg.proxy_tunneling {
 			clientReq.URL.Host = proxy_url.Host
-			r.Appendf("Overriding url: %s", clientReq.URL)
-		}
-		if params.showHeaders {
-			r.Appendf("Using proxy: %s", proxy_url)
+			r.Appendf("Overriding url: %s", redactProxyArgs(clientReq.URL.String()))
+		} else if params.proto == "h2" || params.proto == "h2c" {
+			clog.Error = fmt.Sprintf("-proto:%s cannot go through a proxy", params.proto)
+			r.Append(clog.Error)
+			return clog
 		}
 	}
-	res, err := handler.client.callURL(clientReq, params.rtrip)
+	clog.Method = clientReq.Method
+	clog.Url = u.String()
+	if params.socket != "" {
+		clog.Url = unixURL(params.socket, u)
+	}
+	clog.Url = redactProxyArgs(clog.Url)
+	if params.span != nil {
+		defer startClientSpan(params.span, clog, clientReq.Method, u.Host, clientReq.Header)()
+	}
+	timing := &timingTrace{}
+	clientReq = withTrace(clientReq, timing.clientTrace())
+	var trace *connTrace
+	if params.trace {
+		trace = &connTrace{}
+		clientReq = withTrace(clientReq, trace.clientTrace())
+	}
+	callStart := time.Now()
+	res, err := handler.client.callURL(clientReq, params.rtrip, params.proto)
+	clog.Latency = time.Since(callStart)
+	clog.Timing = timing.result()
+	if trace != nil {
+		clog.Trace = trace.result()
+	}
 	if err != nil {
 		log.Error(err)
 		r.Append(err.Error())
+		clog.Error = err.Error()
 		return clog
 	}
 	clog.Code = uint(res.StatusCode)
-	clog.Url = u.String()
+	clog.Proto = res.Proto
+	if params.tls != nil {
+		tlstools.AppendConnectionState(r, res.TLS)
+	}
 
 	if err != nil {
-		r.Appendf("Couldn't call %s: %s\n", u, err.Error())
+		r.Appendf("Couldn't call %s: %s\n", redactProxyArgs(u.String()), err.Error())
 		return clog
 	} else if res == nil {
-		r.Appendf("Couldn't call %s by some reason\n", u)
+		r.Appendf("Couldn't call %s by some reason\n", redactProxyArgs(u.String()))
 		return clog
 	}
 	err = TreatResponse(clog, res, params, handler.cfg.insecure)
+	clog.Latency = time.Since(callStart)
 
 	c := res.StatusCode
 
@@ -210,7 +410,12 @@ func TreatResponse(clog *data.CommandLog, res *http.Response, params *reqParams,
 	}
 	isHopServer := res.Header.Get("Server") == "hop"
 	if isHopServer || res.ContentLength < 1024 {
-		if body, err := io.ReadAll(res.Body); err == nil {
+		received := time.Now()
+		body, err := io.ReadAll(res.Body)
+		if clog.Timing != nil {
+			clog.Timing.Transfer = time.Since(received)
+		}
+		if err == nil {
 			if isHopServer {
 				if err = json.Unmarshal(body, &clog.Response); err != nil {
 					r.Append(string(body))
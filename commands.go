@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
 	"strconv"
@@ -18,6 +17,8 @@ import (
 
 type cmdContext struct {
 	skip, not bool
+	// clog is the log of the command being executed.
+	clog *data.CommandLog
 }
 
 func makeReq(rlog *data.RequestLog, req *http.Request) (*reqParams, error) {
@@ -31,7 +32,7 @@ func makeReq(rlog *data.RequestLog, req *http.Request) (*reqParams, error) {
 	ctx := &cmdContext{}
 	for strings.HasPrefix(nextCommand, "-") {
 		clog := &data.CommandLog{
-			Command: nextCommand,
+			Command: redactProxyCommand(nextCommand),
 		}
 		rlog.Process = append(rlog.Process, clog)
 		r := &clog.Output
@@ -39,7 +40,11 @@ func makeReq(rlog *data.RequestLog, req *http.Request) (*reqParams, error) {
 		if err := checkCommand(args, cmd); err != nil {
 			return nil, err
 		}
-		if err := step(ctx, r, req, rp, cmd, args); err != nil {
+		ctx.clog = clog
+		start := time.Now()
+		err := step(ctx, r, req, rp, cmd, args)
+		clog.Duration = time.Since(start)
+		if err != nil {
 			r.Appendf("Error execuing %s(%s): %v", cmd, args, err)
 			return nil, err
 		}
@@ -51,7 +56,12 @@ func makeReq(rlog *data.RequestLog, req *http.Request) (*reqParams, error) {
 			return nil, nil
 		}
 		var err error
-		if rp.url, err = tools.BuildURL(nextCommand, path); err != nil {
+		if strings.HasPrefix(nextCommand, "unix:") {
+			rp.socket, rp.url, err = parseUnixTarget(nextCommand, path)
+		} else {
+			rp.url, err = tools.BuildURL(nextCommand, path)
+		}
+		if err != nil {
 			return nil, err
 		}
 	}
@@ -69,6 +79,7 @@ func step(ctx *cmdContext, r *tools.ArrLog, req *http.Request, rp *reqParams, co
 		ctx.skip = false
 		return nil
 	}
+	commandsTotal.Inc(command)
 	switch command {
 	case "-help":
 		for k, v := range help {
@@ -81,16 +92,25 @@ func step(ctx *cmdContext, r *tools.ArrLog, req *http.Request, rp *reqParams, co
 			"\tthis will call hop1 which will call hop2 with forwarded header A",
 			"curl hop1/-rnd:50/hop2/hop3/-on:hop2/-code:500",
 			"\tthis will call hop1 which will call hop2 or hop3 (50%). hop2 would call hop3 and return error code 500")
+	case "-history":
+		f, err := parseHistoryArgs(args)
+		if err != nil {
+			return err
+		}
+		for _, rec := range requests.query(f) {
+			r.Append(historySummary(rec))
+		}
 	case "-wait":
 		d, err := strconv.Atoi(args)
 		if err != nil {
 			return err
 		}
+		fireFault(req, command, args)
 		time.Sleep(time.Duration(d) * time.Millisecond)
 		r.Appendf("Waited for %d ms", d)
 	case "-info":
 		rp.showHeaders = true
-		dump, err := httputil.DumpRequest(req, req.ContentLength < 1024)
+		dump, err := dumpRequest(req, req.ContentLength < 1024)
 		if err == nil {
 			for _, line := range strings.Split(string(dump), "\r\n") {
 				r.Appendf("%s", line)
@@ -98,10 +118,70 @@ func step(ctx *cmdContext, r *tools.ArrLog, req *http.Request, rp *reqParams, co
 		} else {
 			r.Appendf("Error: %s", err)
 		}
+		if h, via := proxyHeader(req); h != nil {
+			if h.Source != nil {
+				r.Appendf("PROXY protocol v%d from %s: %s -> %s", h.Version, via, h.Source, h.Destination)
+			} else {
+				r.Appendf("PROXY protocol v%d from %s: not proxied", h.Version, via)
+			}
+		}
 	case "-method":
 		rp.method = args
 	case "-rtrip":
 		rp.rtrip = true
+	case "-proto":
+		if _, ok := protocols[args]; !ok {
+			return fmt.Errorf("unknown protocol %q", args)
+		}
+		rp.proto = args
+		r.Appendf("Will use %s for the following request", args)
+	case "-ws":
+		p, err := parseWSArgs(args)
+		if err != nil {
+			return err
+		}
+		rp.ws = p
+		r.Appendf("Will send %d WebSocket messages every %s to the following hop", p.n, p.interval)
+	case "-tcp", "-udp":
+		d, err := parseDialArgs(args)
+		if err != nil {
+			return err
+		}
+		network := strings.TrimPrefix(command, "-")
+		latency, err := dialProbe(r, network, d)
+		if ctx.clog != nil {
+			ctx.clog.Dial = network + "://" + d.addr
+			ctx.clog.Latency = latency
+			if err != nil {
+				ctx.clog.Error = err.Error()
+			}
+		}
+	case "-sni", "-tlsver", "-ciphers", "-curves", "-alpn", "-insecure":
+		return tlsStep(r, rp, command, args)
+	case "-proxy":
+		u, err := parseProxyURL(args)
+		if err != nil {
+			return err
+		}
+		rp.proxy, rp.noProxy = u, false
+		r.Appendf("Will call the following hop through proxy %s", u.Redacted())
+	case "-noproxy":
+		rp.proxy, rp.noProxy = nil, true
+		r.Append("Will call the following hop directly")
+	case "-proxyproto":
+		h, err := parseProxyProtoArgs(args, req)
+		if err != nil {
+			return err
+		}
+		rp.proxyHeader = h
+		if h.Source != nil {
+			r.Appendf("Will send the PROXY protocol v%d header with source %v", h.Version, h.Source)
+		} else {
+			r.Appendf("Will send the PROXY protocol v%d header with the local address as source", h.Version)
+		}
+	case "-trace":
+		rp.trace = true
+		r.Append("Will trace the connection of the following request")
 	case "-tls":
 		rp.tlsInfo = true
 		r.Append("Server request TLS info:")
@@ -130,6 +210,7 @@ func step(ctx *cmdContext, r *tools.ArrLog, req *http.Request, rp *reqParams, co
 		if err != nil {
 			return err
 		}
+		fireFault(req, command, args)
 		rp.code.Set(c)
 		r.Appendf("Returning code %d", rp.code)
 	case "-rsize":
@@ -192,10 +273,48 @@ func step(ctx *cmdContext, r *tools.ArrLog, req *http.Request, rp *reqParams, co
 			ctx.skip = !ctx.skip
 			ctx.not = false
 		}
+	case "-ready", "-live":
+		ok, d, err := parseProbeArgs(args)
+		if err != nil {
+			return err
+		}
+		p := readiness
+		if command == "-live" {
+			p = liveness
+		}
+		if !ok {
+			fireFault(req, command, args)
+		}
+		p.set(ok, d)
+		if d > 0 {
+			r.Appendf("Set %s state to %v for %s", p.name, ok, d)
+		} else {
+			r.Appendf("Set %s state to %v", p.name, ok)
+		}
+	case "-log":
+		spec, err := parseLogArgs(args)
+		if err != nil {
+			return err
+		}
+		n, err := spec.write(spec.writer())
+		if err != nil {
+			return err
+		}
+		r.Appendf("Wrote %d %s %s entries (%d bytes) to %s", spec.n, spec.format, spec.level, n, spec.to)
 	case "-quit":
-		r.Appendln("Quitting")
-		defer q(1)
+		var drain time.Duration
+		if args != "" {
+			var err error
+			if drain, err = parseDuration(args); err != nil {
+				return err
+			}
+			r.Appendf("Quitting after draining for %s", drain)
+		} else {
+			r.Appendln("Quitting")
+		}
+		defer requestStop(drain)
 	case "-crash":
+		fireFault(req, command, args)
 		defer q(2)
 	}
 	return nil
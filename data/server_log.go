@@ -1,22 +1,92 @@
 package data
 
-import "github.com/0x656b694d/hop/tools"
+import (
+	"time"
+
+	"github.com/0x656b694d/hop/tools"
+)
+
+// Timing splits the latency of a downstream call. The phases don't overlap,
+// so they sum up to about the whole latency. Transfer is the time to read
+// the response body, which is read only from hop or when shorter than 1 KiB.
+type Timing struct {
+	DNS       time.Duration `json:"dns,omitempty"`
+	Connect   time.Duration `json:"connect,omitempty"`
+	TLS       time.Duration `json:"tls,omitempty"`
+	FirstByte time.Duration `json:"first-byte,omitempty"`
+	Transfer  time.Duration `json:"transfer,omitempty"`
+}
+
+// ConnTrace describes the connections used for a downstream call.
+type ConnTrace struct {
+	DNS       []string      `json:"dns,omitempty"`
+	DNSError  string        `json:"dns-error,omitempty"`
+	Dials     []DialTrace   `json:"dials,omitempty"`
+	Conns     []ConnInfo    `json:"connections,omitempty"`
+	TLS       *TLSTrace     `json:"tls,omitempty"`
+	FirstByte time.Duration `json:"first-byte,omitempty"`
+	Got100    int           `json:"got-100-continue,omitempty"`
+}
+
+// DialTrace is a connection attempt to an address.
+type DialTrace struct {
+	Network  string        `json:"network,omitempty"`
+	Addr     string        `json:"addr,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// ConnInfo is a connection obtained for the request. There is more than one
+// when the transport retries on a new connection, e.g. after a stale one.
+type ConnInfo struct {
+	Local    string        `json:"local,omitempty"`
+	Remote   string        `json:"remote,omitempty"`
+	Reused   bool          `json:"reused"`
+	WasIdle  bool          `json:"was-idle,omitempty"`
+	IdleTime time.Duration `json:"idle-time,omitempty"`
+}
+
+// TLSTrace is the outcome of the TLS handshake.
+type TLSTrace struct {
+	Version    string        `json:"version,omitempty"`
+	Cipher     string        `json:"cipher,omitempty"`
+	Protocol   string        `json:"protocol,omitempty"`
+	ServerName string        `json:"server-name,omitempty"`
+	Resumed    bool          `json:"resumed,omitempty"`
+	Duration   time.Duration `json:"duration,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
 
 type CommandLog struct {
-	Command  string       `json:"command,omitempty"`
-	Output   tools.ArrLog `json:"output,omitempty"`
-	Url      string       `json:"url,omitempty"`
-	Code     uint         `json:"code,omitempty"`
-	Response *ServerLog   `json:"response,omitempty"`
-	Error    string       `json:"error,omitempty"`
+	Command  string          `json:"command,omitempty"`
+	Output   tools.ArrLog    `json:"output,omitempty"`
+	Duration time.Duration   `json:"duration,omitempty"`
+	Method   string          `json:"method,omitempty"`
+	Url      string          `json:"url,omitempty"`
+	Dial     string          `json:"dial,omitempty"`
+	Proxy    string          `json:"proxy,omitempty"`
+	Proto    string          `json:"proto,omitempty"`
+	Code     uint            `json:"code,omitempty"`
+	Latency  time.Duration   `json:"latency,omitempty"`
+	RTT      []time.Duration `json:"rtt,omitempty"`
+	Timing   *Timing         `json:"timing,omitempty"`
+	Trace    *ConnTrace      `json:"trace,omitempty"`
+	Response *ServerLog      `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
 }
 
 type RequestLog struct {
-	Method  string        `json:"method,omitempty"`
-	Path    string        `json:"path,omitempty"`
-	From    string        `json:"from,omitempty"`
-	Size    int64         `json:"size,omitempty"`
-	Process []*CommandLog `json:"process,omitempty"`
+	Method   string        `json:"method,omitempty"`
+	Path     string        `json:"path,omitempty"`
+	From     string        `json:"from,omitempty"`
+	Via      string        `json:"via,omitempty"`
+	Proto    string        `json:"proto,omitempty"`
+	Size     int64         `json:"size,omitempty"`
+	Code     int           `json:"code,omitempty"`
+	TraceID  string        `json:"trace-id,omitempty"`
+	Start    time.Time     `json:"start,omitzero"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Process  []*CommandLog `json:"process,omitempty"`
 }
 
 type ServerLog struct {
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/0x656b694d/hop/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// startTcpEcho serves a TCP echo on the given port, if not 0.
+func (cfg *config) startTcpEcho(quit chan<- int) net.Listener {
+	if cfg.port_tcp == 0 {
+		return nil
+	}
+	addr := net.JoinHostPort(cfg.localhost, fmt.Sprint(cfg.port_tcp))
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Panicf("failed to start TCP echo: %v", err)
+	}
+	go func() {
+		log.Info("Serving TCP echo on ", addr)
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Info(err)
+				break
+			}
+			go func() {
+				defer conn.Close()
+				n, err := io.Copy(conn, conn)
+				log.Debugf("TCP echo of %d bytes to %s: %v", n, conn.RemoteAddr(), err)
+			}()
+		}
+		quit <- 6
+	}()
+	return l
+}
+
+// startUdpEcho serves a UDP echo on the given port, if not 0.
+func (cfg *config) startUdpEcho(quit chan<- int) net.PacketConn {
+	if cfg.port_udp == 0 {
+		return nil
+	}
+	addr := net.JoinHostPort(cfg.localhost, fmt.Sprint(cfg.port_udp))
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Panicf("failed to start UDP echo: %v", err)
+	}
+	go func() {
+		log.Info("Serving UDP echo on ", addr)
+		buf := make([]byte, 64<<10)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				log.Info(err)
+				break
+			}
+			if _, err := pc.WriteTo(buf[:n], from); err != nil {
+				log.Debugf("UDP echo to %s: %v", from, err)
+			}
+		}
+		quit <- 7
+	}()
+	return pc
+}
+
+// dialArgs are the arguments of the -tcp and -udp commands.
+type dialArgs struct {
+	addr    string
+	send    string
+	timeout time.Duration
+}
+
+// parseDialArgs parses the -tcp and -udp arguments, e.g.
+// "host:port,send=hello,timeout=2s".
+func parseDialArgs(args string) (*dialArgs, error) {
+	parts := strings.Split(args, ",")
+	d := &dialArgs{addr: parts[0], timeout: 5 * time.Second}
+	if _, _, err := net.SplitHostPort(d.addr); err != nil {
+		return nil, err
+	}
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("missing value of %q", p)
+		}
+		var err error
+		switch kv[0] {
+		case "send":
+			d.send, err = url.PathUnescape(kv[1])
+		case "timeout":
+			d.timeout, err = parseDuration(kv[1])
+		default:
+			err = fmt.Errorf("unexpected argument %q", p)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// dialProbe connects to the address and exchanges the bytes if asked. UDP
+// has no handshake, so something is always sent. It returns the connect
+// latency.
+func dialProbe(r *tools.ArrLog, network string, d *dialArgs) (time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout(network, d.addr, d.timeout)
+	latency := time.Since(start)
+	if err != nil {
+		r.Appendf("Failed to connect to %s/%s in %s: %v", network, d.addr, latency, err)
+		return latency, err
+	}
+	defer conn.Close()
+	r.Appendf("Connected to %s/%s (%s -> %s) in %s", network, d.addr, conn.LocalAddr(), conn.RemoteAddr(), latency)
+
+	send := d.send
+	if send == "" {
+		if network == "tcp" {
+			return latency, nil
+		}
+		send = "hop"
+	}
+	conn.SetDeadline(time.Now().Add(d.timeout))
+	sent := time.Now()
+	if _, err = conn.Write([]byte(send)); err != nil {
+		r.Appendf("Failed to send %d bytes: %v", len(send), err)
+		return latency, err
+	}
+	buf := make([]byte, 64<<10)
+	n, err := conn.Read(buf)
+	rtt := time.Since(sent)
+	if err != nil {
+		r.Appendf("Sent %d bytes, no reply in %s: %v", len(send), rtt, err)
+		return latency, err
+	}
+	r.Appendf("Sent %d bytes, received %d bytes in %s: %q", len(send), n, rtt, buf[:n])
+	return latency, nil
+}
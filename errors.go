@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var errMissingArguments error = errors.New("missing arguments")
@@ -20,7 +21,7 @@ func checkCommand(args, command string) error {
 	var err error
 	if !ok {
 		err = errNoSuchCommand
-	} else if args == "" && c[0] != "" {
+	} else if args == "" && c[0] != "" && !strings.HasPrefix(c[0], "[") {
 		err = errMissingArguments
 	}
 	return wrapErr(err, command)
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0x656b694d/hop/data"
+	"github.com/0x656b694d/hop/tools"
+	log "github.com/sirupsen/logrus"
+)
+
+// event is a handled request or a fired fault, pushed to the /events
+// subscribers.
+type event struct {
+	kind     string
+	commands []string
+	code     int
+	data     any
+}
+
+type requestEvent struct {
+	Host string `json:"host"`
+	*accessEntry
+}
+
+type faultEvent struct {
+	Time   string `json:"time"`
+	Host   string `json:"host"`
+	Fault  string `json:"fault"`
+	Args   string `json:"args,omitempty"`
+	Remote string `json:"remote"`
+	Path   string `json:"path"`
+}
+
+// broker fans the events out to the subscribers. A slow subscriber misses
+// the events which don't fit in its buffer.
+type broker struct {
+	mu   sync.Mutex
+	subs map[chan *event]struct{}
+}
+
+var (
+	events      = &broker{subs: map[chan *event]struct{}{}}
+	hostname, _ = os.Hostname()
+
+	eventsKeepAlive = 15 * time.Second
+)
+
+func (b *broker) subscribe() chan *event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan *event, 64)
+	b.subs[ch] = struct{}{}
+	return ch
+}
+
+func (b *broker) unsubscribe(ch chan *event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+func (b *broker) publish(e *event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (b *broker) active() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs) != 0
+}
+
+// close ends all the streams, so that the admin server can stop.
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// publishRequest pushes the summary of a handled request.
+func publishRequest(r *data.RequestLog, bytes int) {
+	if !events.active() {
+		return
+	}
+	entry := newAccessEntry(r, bytes)
+	events.publish(&event{
+		kind:     "request",
+		commands: entry.Commands,
+		code:     r.Code,
+		data:     &requestEvent{hostname, entry},
+	})
+}
+
+// fireFault counts a fault injected by a command and pushes it.
+func fireFault(req *http.Request, command, args string) {
+	faultsTotal.Inc(command)
+	if !events.active() {
+		return
+	}
+	e := &faultEvent{
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+		Host:   hostname,
+		Fault:  command,
+		Args:   args,
+		Remote: req.RemoteAddr,
+	}
+	if req.URL != nil {
+		e.Path = redactProxyArgs(req.URL.EscapedPath())
+	}
+	events.publish(&event{kind: "fault", commands: []string{command}, data: e})
+}
+
+// eventFilter selects the events by type, command and response status. The
+// faults have no status, so they don't pass a status filter.
+type eventFilter struct {
+	kind    string
+	command string
+	status  string
+}
+
+func (f *eventFilter) match(e *event) bool {
+	if f.kind != "" && f.kind != e.kind {
+		return false
+	}
+	if f.status != "" && (e.kind != "request" || !matchStatus(f.status, e.code)) {
+		return false
+	}
+	if f.command == "" {
+		return true
+	}
+	for _, c := range e.commands {
+		if cmd, _ := tools.SplitCommandArgs(c); cmd == f.command {
+			return true
+		}
+	}
+	return false
+}
+
+// serveEvents streams the events as server-sent events, filtered by the
+// type, command and status query parameters.
+func serveEvents(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	f := &eventFilter{
+		kind:    q.Get("type"),
+		command: q.Get("command"),
+		status:  strings.ToLower(q.Get("status")),
+	}
+	if f.command != "" && !strings.HasPrefix(f.command, "-") {
+		f.command = "-" + f.command
+	}
+	rc := http.NewResponseController(w)
+	// The stream outlives the server write timeout.
+	rc.SetWriteDeadline(time.Time{})
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !f.match(e) {
+				continue
+			}
+			b, err := json.Marshal(e.data)
+			if err != nil {
+				log.Error("Error marshalling event: ", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.kind, b)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x656b694d/hop/data"
+	"github.com/0x656b694d/hop/proxyproto"
+	log "github.com/sirupsen/logrus"
+)
+
+// forwardTransport calls the targets of the forward proxy directly.
+var forwardTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	MaxIdleConns:        10,
+	IdleConnTimeout:     10 * time.Minute,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+// isProxyRequest tells if the request is for a forward proxy: a CONNECT or
+// an absolute URI.
+func isProxyRequest(req *http.Request) bool {
+	return req.Method == http.MethodConnect || req.URL.IsAbs()
+}
+
+// statusReset is the code logged for a connection reset by a rule, as nginx
+// logs 444 for a connection closed without a response.
+const statusReset = 444
+
+// countingWriter records the status code and the number of bytes of the
+// response, or the reset of the connection.
+type countingWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+	reset bool
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveProxy forwards the request or tunnels the connection to the target,
+// applying the fault rules, and logs it as any handled request. With an
+// upstream, the request is forwarded to it instead of its own target.
+func (handler *hopHandler) serveProxy(w http.ResponseWriter, req *http.Request, upstream *url.URL) {
+	slog := *handler.log
+	slog.Request = &data.RequestLog{
+		Path:   req.URL.String(),
+		Method: req.Method,
+		From:   req.RemoteAddr,
+		Proto:  req.Proto,
+		Size:   req.ContentLength,
+		Start:  time.Now(),
+	}
+	target := req.URL.Host
+	switch {
+	case upstream != nil:
+		target = upstream.Host
+	case req.Method == http.MethodConnect:
+		target = req.Host
+		slog.Request.Path = target
+	}
+	clog := &data.CommandLog{Command: "proxy", Method: req.Method, Url: slog.Request.Path}
+	slog.Request.Process = []*data.CommandLog{clog}
+	cw := &countingWriter{ResponseWriter: w}
+	defer func() {
+		code := fmt.Sprint(cw.code)
+		slog.Request.Code = cw.code
+		if cw.reset {
+			code = "reset"
+			slog.Request.Code = statusReset
+		}
+		slog.Request.Duration = time.Since(slog.Request.Start)
+		clog.Duration = slog.Request.Duration
+		requestsTotal.Inc(methodLabel(req.Method), code)
+		requestDuration.Observe(slog.Request.Duration.Seconds(), methodLabel(req.Method))
+		requests.add(&slog)
+		access.log(req, slog.Request, cw.bytes)
+		publishRequest(slog.Request, cw.bytes)
+	}()
+
+	rule := proxyRules.match(req, target)
+	if rule != nil {
+		clog.Output.Appendf("Matched rule %s", rule)
+		if !applyRule(cw, req, rule, clog) {
+			return
+		}
+	}
+	if req.Method == http.MethodConnect && upstream == nil {
+		tunnel(cw, req, target, clog)
+		return
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if upstream != nil {
+				pr.SetURL(upstream)
+				pr.SetXForwarded()
+				clog.Url = pr.Out.URL.String()
+			} else {
+				pr.Out.URL = pr.In.URL
+				pr.Out.Host = pr.In.Host
+			}
+			if rule != nil {
+				rewriteRequest(pr.Out, rule, clog)
+			}
+		},
+		Transport: forwardTransport,
+		ModifyResponse: func(res *http.Response) error {
+			clog.Code = uint(res.StatusCode)
+			clog.Proto = res.Proto
+			clog.Latency = time.Since(slog.Request.Start)
+			if rule != nil {
+				rewriteResponse(res, rule, clog)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			clog.Error = err.Error()
+			clog.Output.Append(err.Error())
+			w.WriteHeader(http.StatusBadGateway)
+		},
+		ErrorLog: stdlog.New(log.StandardLogger().Writer(), "proxy: ", 0),
+	}
+	proxy.ServeHTTP(cw, req)
+}
+
+// applyRule injects the faults of the rule. It tells if the request should
+// still be proxied.
+func applyRule(w *countingWriter, req *http.Request, rule *proxyRule, clog *data.CommandLog) bool {
+	if rule.delay > 0 {
+		fireFault(req, "proxy-delay", rule.delay.String())
+		time.Sleep(rule.delay)
+		clog.Output.Appendf("Delayed for %s", rule.delay)
+	}
+	if rule.reset {
+		fireFault(req, "proxy-reset", "")
+		clog.Output.Append("Reset the connection")
+		clog.Error = "connection reset by hop"
+		w.reset = true
+		resetConn(w)
+		return false
+	}
+	if rule.code != 0 {
+		fireFault(req, "proxy-code", fmt.Sprint(rule.code))
+		clog.Output.Appendf("Aborted with code %d", rule.code)
+		http.Error(w, fmt.Sprintf("Aborted by hop with code %d", rule.code), rule.code)
+		return false
+	}
+	return true
+}
+
+// rewriteRequest rewrites the headers of the request to the target.
+func rewriteRequest(req *http.Request, rule *proxyRule, clog *data.CommandLog) {
+	for _, h := range rule.headers {
+		fireFault(req, "proxy-header", h.name+":"+h.value)
+		h.apply(req.Header)
+		clog.Output.Appendf("Rewrote the request header %s: %s", h.name, h.value)
+	}
+}
+
+// rewriteResponse rewrites the headers of the response, and truncates its
+// body. The Content-Length is kept, so that the client sees a broken
+// response.
+func rewriteResponse(res *http.Response, rule *proxyRule, clog *data.CommandLog) {
+	for _, h := range rule.rheaders {
+		fireFault(res.Request, "proxy-rheader", h.name+":"+h.value)
+		h.apply(res.Header)
+		clog.Output.Appendf("Rewrote the response header %s: %s", h.name, h.value)
+	}
+	if rule.truncate >= 0 {
+		fireFault(res.Request, "proxy-truncate", fmt.Sprint(rule.truncate))
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(res.Body, rule.truncate), res.Body}
+		clog.Output.Appendf("Truncated the response body to %d bytes", rule.truncate)
+	}
+}
+
+// resetConn closes the client connection with a TCP reset. On HTTP/2 only
+// the stream is reset.
+func resetConn(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := tcpConn(conn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// tcpConn unwraps the TCP connection.
+func tcpConn(conn net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, true
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *proxyproto.Conn:
+			conn = c.Conn
+		default:
+			return nil, false
+		}
+	}
+}
+
+// tunnel connects the client to the target of a CONNECT request.
+func tunnel(w *countingWriter, req *http.Request, target string, clog *data.CommandLog) {
+	start := time.Now()
+	upstream, err := net.DialTimeout("tcp", target, 30*time.Second)
+	clog.Latency = time.Since(start)
+	if err != nil {
+		clog.Error = err.Error()
+		clog.Output.Appendf("Failed to connect to %s: %v", target, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+	clog.Output.Appendf("Connected to %s in %s", target, clog.Latency)
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		clog.Error = err.Error()
+		clog.Output.Appendf("Cannot tunnel: %v", err)
+		http.Error(w, err.Error(), http.StatusHTTPVersionNotSupported)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		clog.Error = err.Error()
+		return
+	}
+	w.code = http.StatusOK
+	clog.Code = http.StatusOK
+
+	var sent, received atomic.Int64
+	done := make(chan struct{})
+	go func() {
+		// The client may have sent some bytes after the request.
+		n, _ := io.Copy(upstream, rw.Reader)
+		sent.Add(n)
+		if tcp, ok := upstream.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		close(done)
+	}()
+	n, err := io.Copy(conn, upstream)
+	received.Add(n)
+	if tcp, ok := tcpConn(conn); ok {
+		tcp.CloseWrite()
+	}
+	<-done
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		clog.Error = err.Error()
+	}
+	w.bytes = int(received.Load())
+	clog.Output.Appendf("Tunnel to %s closed after %s: sent %d bytes, received %d bytes",
+		target, time.Since(start), sent.Load(), received.Load())
+}
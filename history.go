@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/0x656b694d/hop/data"
+	log "github.com/sirupsen/logrus"
+)
+
+// history keeps the last handled requests.
+type history struct {
+	mu      sync.Mutex
+	records []*data.ServerLog
+	next    int
+	full    bool
+}
+
+var requests = newHistory(100)
+
+func newHistory(size int) *history {
+	return &history{records: make([]*data.ServerLog, size)}
+}
+
+func (h *history) add(slog *data.ServerLog) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.records) == 0 {
+		return
+	}
+	h.records[h.next] = slog
+	h.next = (h.next + 1) % len(h.records)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// query returns the last records matching the filter, oldest first.
+func (h *history) query(f *historyFilter) []*data.ServerLog {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := []*data.ServerLog{}
+	for i := 1; i <= len(h.records); i++ {
+		if f.n > 0 && len(result) == f.n {
+			break
+		}
+		idx := (h.next - i + len(h.records)) % len(h.records)
+		if idx >= h.next && !h.full {
+			break
+		}
+		if r := h.records[idx]; f.match(r) {
+			result = append(result, r)
+		}
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// historyFilter selects the records by the response status, a substring of
+// the path and a substring of the remote address.
+type historyFilter struct {
+	n      int
+	status string
+	path   string
+	from   string
+}
+
+// set sets a filter parameter. The status is a code, like 503, or a class,
+// like 5xx.
+func (f *historyFilter) set(key, value string) error {
+	switch key {
+	case "n":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		f.n = n
+	case "status":
+		f.status = strings.ToLower(value)
+	case "path":
+		f.path = value
+	case "from":
+		f.from = value
+	default:
+		return fmt.Errorf("unknown history filter %q", key)
+	}
+	return nil
+}
+
+func (f *historyFilter) match(r *data.ServerLog) bool {
+	req := r.Request
+	if req == nil {
+		return false
+	}
+	if f.status != "" && !matchStatus(f.status, req.Code) {
+		return false
+	}
+	return strings.Contains(req.Path, f.path) && strings.Contains(req.From, f.from)
+}
+
+// matchStatus tells if the code is the status, like 503, or belongs to the
+// class, like 5xx.
+func matchStatus(status string, code int) bool {
+	c := strconv.Itoa(code)
+	if strings.HasSuffix(status, "xx") {
+		return strings.HasPrefix(c, strings.TrimSuffix(status, "xx")) && len(c) == len(status)
+	}
+	return c == status
+}
+
+// parseHistoryArgs parses the -history command arguments: the number of
+// records and the filters, e.g. "10,status=5xx,path=api".
+func parseHistoryArgs(args string) (*historyFilter, error) {
+	f := &historyFilter{}
+	if args == "" {
+		return f, nil
+	}
+	for i, p := range strings.Split(args, ",") {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 1 && i == 0 {
+			kv = []string{"n", p}
+		} else if len(kv) == 1 {
+			return nil, fmt.Errorf("missing value of %q", p)
+		}
+		if err := f.set(kv[0], kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// historySummary formats a record as a single line.
+func historySummary(r *data.ServerLog) string {
+	req := r.Request
+	return fmt.Sprintf("%s %s %s %s %d %s", req.Start.Format("2006-01-02T15:04:05.000Z07:00"),
+		req.From, req.Method, req.Path, req.Code, req.Duration)
+}
+
+// serveHistory returns the records matching the query parameters as JSON.
+func serveHistory(w http.ResponseWriter, req *http.Request) {
+	f := &historyFilter{}
+	for k, v := range req.URL.Query() {
+		if err := f.set(k, v[0]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	b, err := json.MarshalIndent(requests.query(f), "", "  ")
+	if err != nil {
+		log.Error("Error marshalling history: ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
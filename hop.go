@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -9,39 +8,65 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/0x656b694d/hop/data"
 	"github.com/0x656b694d/hop/seqdiag"
 	"github.com/0x656b694d/hop/tlstools"
+	"github.com/0x656b694d/hop/tracing"
 	log "github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
 )
 
 var (
 	help = map[string][2]string{
-		"-code":    {"N", "responde with HTTP code N"},
-		"-crash":   {"", "stops the server without a response"},
-		"-fheader": {"H", "forward incoming header H to the following request"},
-		"-header":  {"H=V", "add header H: V to the following request"},
-		"-help":    {"", "return help message"},
-		"-if":      {"H=V", "execute next command if header H contains substring V"},
-		"-info":    {"", "return some info about the request"},
-		"-method":  {"M", "use M method for the request"},
-		"-rtrip":   {"", "do a round-trip request (no follow redirects and such)"},
-		"-tls":     {"", "include verbose TLS info"},
-		"-not":     {"", "reverts the effect of the next boolean command (if, on)"},
-		"-on":      {"H", "executes next command if the server host name contains substring H"},
-		"-quit":    {"", "stops the server with a nice response"},
-		"-rheader": {"H=V", "add header H: V to the reponse"},
-		"-rnd":     {"P", "execute next command with P% probability"},
-		"-rsize":   {"B", "add B bytes of payload to the response"},
-		"-size":    {"B", "add B bytes of payload to the following query"},
-		"-wait":    {"T", "wait for T ms before response"},
-		"-env":     {"V", "return the value of an environment variable"},
-	}
-
-	quit = make(chan int)
+		"-code":       {"N", "responde with HTTP code N"},
+		"-crash":      {"", "stops the server without a response"},
+		"-fheader":    {"H", "forward incoming header H to the following request"},
+		"-header":     {"H=V", "add header H: V to the following request"},
+		"-help":       {"", "return help message"},
+		"-history":    {"[N]", "return the last N handled requests, filtered with ,status=S,path=P,from=A if given"},
+		"-if":         {"H=V", "execute next command if header H contains substring V"},
+		"-info":       {"", "return some info about the request"},
+		"-method":     {"M", "use M method for the request"},
+		"-rtrip":      {"", "do a round-trip request (no follow redirects and such)"},
+		"-tls":        {"", "include verbose TLS info"},
+		"-trace":      {"", "trace the connection of the following request"},
+		"-sni":        {"N", "send the server name N in the TLS handshake of the following request"},
+		"-tlsver":     {"V[-V]", "use the TLS versions in the range, like 1.2-1.3, 1.2- or 1.0, for the following request"},
+		"-ciphers":    {"C,...", "offer the cipher suites C (names or numbers, for TLS 1.0-1.2) for the following request"},
+		"-curves":     {"C,...", "offer the curves C (X25519, P256, P384, P521) for the following request"},
+		"-alpn":       {"P,...", "offer the protocols P with ALPN, like h2,http%2F1.1, for the following request"},
+		"-insecure":   {"[B]", "skip (or not, with false) the server certificate verification for the following request"},
+		"-proto":      {"P", "use protocol P for the following request: h1, h2 (over TLS) or h2c (cleartext, with prior knowledge)"},
+		"-not":        {"", "reverts the effect of the next boolean command (if, on)"},
+		"-on":         {"H", "executes next command if the server host name contains substring H"},
+		"-proxy":      {"URL", "call the following hop through the proxy URL (http, https, socks5 or socks5h, with user:pass@ if needed), unless in NO_PROXY"},
+		"-noproxy":    {"", "call the following hop directly, without a proxy"},
+		"-proxyproto": {"V[,src=A]", "send the PROXY protocol header of version V (v1 or v2) on the connection of the following request, with the client address or A as the source"},
+		"-quit":       {"[T]", "stops the server with a nice response, draining the connections for T (e.g. 10s) before"},
+		"-ready":      {"B[,for=T]", "set the readiness state to B (true or false), for T (e.g. 30s) if given"},
+		"-live":       {"B[,for=T]", "set the liveness state to B (true or false), for T (e.g. 30s) if given"},
+		"-log":        {"[K=V,...]", "write N log lines: level=L,msg=M,n=N (up to 10000),size=B (e.g. 2k, up to 1m; 4m in total),format=json|text|multiline,to=stdout|stderr"},
+		"-rheader":    {"H=V", "add header H: V to the reponse"},
+		"-rnd":        {"P", "execute next command with P% probability"},
+		"-rsize":      {"B", "add B bytes of payload to the response"},
+		"-size":       {"B", "add B bytes of payload to the following query"},
+		"-ws":         {"[n=N,interval=T,msg=M]", "send N messages (the following path by default) every T through a WebSocket to the following hop"},
+		"-tcp":        {"A[,send=S,timeout=T]", "connect to TCP address A (host:port), send S and wait T (5s) for the reply if given"},
+		"-udp":        {"A[,send=S,timeout=T]", "send S (hop) to UDP address A (host:port) and wait T (5s) for the reply"},
+		"-wait":       {"T", "wait for T ms before response"},
+		"-env":        {"V", "return the value of an environment variable"},
+	}
+
+	// quit receives 2 on -crash, or the number of a server which has stopped.
+	quit = make(chan int, 8)
+	// stop receives the drain duration on -quit.
+	stop = make(chan time.Duration, 1)
 
 	http_proxy_url  *url.URL
 	https_proxy_url *url.URL
@@ -54,23 +79,54 @@ type config struct {
 	static_ca       bool
 	port_http       uint
 	port_https      uint
+	port_admin      uint
+	port_tcp        uint
+	port_udp        uint
+	listen          []string
+	proxy_protocol  bool
+	forward_proxy   bool
+	proxy_rules     []string
+	upstream        string
+	upstream_url    *url.URL
+	http2           bool
 	http_proxy      string
 	https_proxy     string
+	no_proxy        string
 	proxy_tunneling bool
 	cacerts         []string
 	cacert          string
 	cakey           string
 	certificate     string
 	mtls            bool
+	tls_min_version string
+	tls_max_version string
+	tls_ciphers     string
+	tls_curves      string
+	client_auth     string
+	tls_listen      []string
 	key             string
 	localhost       string
 	serviceNames    []string
 	seqdiag         bool
+	diagram         string
+	otlp_endpoint   string
+	otlp_file       string
+
+	history_size uint
+
+	access_log        string
+	access_log_format string
+	access_log_sample float64
+
+	shutdown_delay      time.Duration
+	shutdown_timeout    time.Duration
+	shutdown_fail_ready bool
 }
 
 func getConfig() *config {
 	var port_http uint64 = 80
 	var port_https uint64 = 443
+	var port_admin uint64
 	var err error
 	if s := os.Getenv("PORT"); len(s) != 0 {
 		port_http, err = strconv.ParseUint(s, 10, 16)
@@ -84,6 +140,12 @@ func getConfig() *config {
 			log.Panic(err)
 		}
 	}
+	if s := os.Getenv("PORT_ADMIN"); len(s) != 0 {
+		port_admin, err = strconv.ParseUint(s, 10, 16)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
 
 	cfg := &config{}
 
@@ -93,11 +155,22 @@ func getConfig() *config {
 	flag.StringVarP(&cfg.localhost, "interface", "i", "0.0.0.0", "the interface to listen on")
 	flag.UintVarP(&cfg.port_http, "port-http", "", uint(port_http), "port HTTP")
 	flag.BoolVarP(&cfg.insecure, "insecure", "k", false, "client to skip TLS verification")
-	flag.BoolVarP(&cfg.seqdiag, "seqdiag", "", false, "sequence diagram output")
+	flag.BoolVarP(&cfg.seqdiag, "seqdiag", "", false, "sequence diagram output (same as --diagram seqdiag)")
+	flag.StringVarP(&cfg.diagram, "diagram", "", "", "diagram output format ("+strings.Join(seqdiag.Names(), ", ")+")")
 
 	flag.UintVarP(&cfg.port_https, "port-https", "", uint(port_https), "port HTTPS")
+	flag.UintVarP(&cfg.port_admin, "port-admin", "", uint(port_admin), "port of the admin endpoints (metrics, probes), 0 to disable")
+	flag.UintVarP(&cfg.port_tcp, "port-tcp", "", 0, "port of the TCP echo, 0 to disable")
+	flag.UintVarP(&cfg.port_udp, "port-udp", "", 0, "port of the UDP echo, 0 to disable")
+	flag.StringArrayVarP(&cfg.listen, "listen", "", nil, "additional HTTP listener, like unix:/path.sock")
+	flag.BoolVarP(&cfg.proxy_protocol, "proxy-protocol", "", false, "accept the PROXY protocol header on the HTTP, HTTPS and additional listeners")
+	flag.BoolVarP(&cfg.forward_proxy, "forward-proxy", "", false, "act as a forward proxy for the absolute URI and CONNECT requests")
+	flag.StringVarP(&cfg.upstream, "upstream", "", "", "reverse proxy every request to the upstream URL")
+	flag.StringArrayVarP(&cfg.proxy_rules, "proxy-rule", "", nil, "fault rule for the proxied requests, like host=api,method=GET,delay=100ms,code=503,percent=50 or host=db,reset")
+	flag.BoolVarP(&cfg.http2, "http2", "", true, "serve HTTP/2: h2 on the HTTPS port, h2c on the HTTP port")
 	flag.StringVarP(&cfg.http_proxy, "http-proxy", "", os.Getenv("http_proxy"), "HTTP proxy")
 	flag.StringVarP(&cfg.https_proxy, "https-proxy", "", os.Getenv("https_proxy"), "HTTPS proxy")
+	flag.StringVarP(&cfg.no_proxy, "no-proxy", "", noProxyEnv(), "comma separated hosts, domains and networks to call without a proxy")
 	flag.BoolVarP(&cfg.proxy_tunneling, "proxy-tunneling", "", false, "use proxy tunneling (if false just put the proxy to the Host: header)")
 	flag.StringArrayVarP(&cfg.cacerts, "cacerts", "", nil, "trusted CA certificate PEM files")
 	flag.StringVarP(&cfg.cacert, "cacert", "", "", "CA certificate to sign server and client certificates")
@@ -105,9 +178,27 @@ func getConfig() *config {
 	flag.StringVarP(&cfg.certificate, "cert", "", "", "server certificate PEM file")
 	flag.StringVarP(&cfg.key, "key", "", "", "server private key PEM file")
 	flag.BoolVarP(&cfg.mtls, "mtls", "m", false, "set client certificate (same as cert)")
+	flag.StringVarP(&cfg.tls_min_version, "tls-min-version", "", "", "minimum TLS version of the HTTPS server: 1.0, 1.1, 1.2 or 1.3")
+	flag.StringVarP(&cfg.tls_max_version, "tls-max-version", "", "", "maximum TLS version of the HTTPS server: 1.0, 1.1, 1.2 or 1.3")
+	flag.StringVarP(&cfg.tls_ciphers, "tls-ciphers", "", "", "cipher suites of the HTTPS server for TLS 1.0-1.2, separated by commas or colons")
+	flag.StringVarP(&cfg.tls_curves, "tls-curves", "", "", "curves of the HTTPS server: X25519, P256, P384, P521, separated by commas or colons")
+	flag.StringVarP(&cfg.client_auth, "client-auth", "", "verify-if-given", "client certificate mode of the HTTPS server: none, request, require, verify-if-given or require-and-verify")
+	flag.StringArrayVarP(&cfg.tls_listen, "tls-listen", "", nil, "additional HTTPS listener with its TLS policy, like port=8443,min=1.0,max=1.1,ciphers=A:B,curves=P256,client-auth=require")
 	flag.StringArrayVarP(&cfg.serviceNames, "name", "n", []string{"localhost"}, "the service DNS name(s) for the certificate")
+	flag.StringVarP(&cfg.otlp_endpoint, "otlp-endpoint", "", os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"), "OTLP/HTTP collector URL to export the spans to, e.g. http://collector:4318/v1/traces")
+	flag.StringVarP(&cfg.otlp_file, "otlp-file", "", "", "JSON lines file to export the spans to")
+	flag.UintVarP(&cfg.history_size, "history", "", 100, "number of handled requests to keep in the history")
+	flag.StringVarP(&cfg.access_log, "access-log", "", "", "access log destination: stdout, stderr or a file")
+	flag.StringVarP(&cfg.access_log_format, "access-log-format", "", "json", "access log format (json, combined)")
+	flag.Float64VarP(&cfg.access_log_sample, "access-log-sample", "", 1, "fraction of the requests to write to the access log")
+	flag.DurationVarP(&cfg.shutdown_delay, "shutdown-delay", "", 0, "on SIGTERM, time to keep serving before stopping the servers")
+	flag.DurationVarP(&cfg.shutdown_timeout, "shutdown-timeout", "", 30*time.Second, "time to wait for the in-flight requests when stopping")
+	flag.BoolVarP(&cfg.shutdown_fail_ready, "shutdown-fail-ready", "", true, "fail the readiness probe when shutting down")
 
 	flag.Parse()
+	if cfg.seqdiag && cfg.diagram == "" {
+		cfg.diagram = "seqdiag"
+	}
 	return cfg
 }
 
@@ -123,6 +214,13 @@ func main() {
 	}
 	tlstools.Init(cfg.static_ca)
 
+	var diagram *seqdiag.Format
+	if cfg.diagram != "" {
+		if diagram = seqdiag.ByName(cfg.diagram); diagram == nil {
+			log.Panicf("unknown diagram format: %s", cfg.diagram)
+		}
+	}
+
 	var err error
 	if len(cfg.https_proxy) != 0 {
 		https_proxy_url, err = url.Parse(cfg.https_proxy)
@@ -133,6 +231,7 @@ func main() {
 	if err != nil {
 		log.Panicf("failed to parse parameters: %s", err)
 	}
+	no_proxy = parseNoProxy(cfg.no_proxy)
 
 	if cfg.cacert != "" {
 		cfg.cacerts = append(cfg.cacerts, cfg.cacert)
@@ -149,7 +248,13 @@ func main() {
 	}
 
 	if flag.NArg() == 1 {
-		u, err := url.Parse(flag.Arg(0))
+		var socket string
+		var u *url.URL
+		if strings.HasPrefix(flag.Arg(0), "unix:") {
+			socket, u, err = parseUnixTarget(flag.Arg(0), "")
+		} else {
+			u, err = url.Parse(flag.Arg(0))
+		}
 		if err != nil {
 			log.Panic(err)
 		}
@@ -159,6 +264,9 @@ func main() {
 		if req, err := BuildRequest(u, http.MethodGet, params.headers, 0); err != nil {
 			log.Error(err)
 		} else {
+			if socket != "" {
+				req = withSocket(req, socket)
+			}
 			if res, err := client.Do(req); err != nil {
 				log.Error(err)
 			} else {
@@ -172,8 +280,8 @@ func main() {
 				}
 				fmt.Println("== Response ==")
 				if clog.Response != nil {
-					if cfg.seqdiag {
-						d, _ := seqdiag.Translate(clog.Response)
+					if diagram != nil {
+						d, _ := diagram.Translate(clog.Response)
 						fmt.Println(d)
 					} else {
 						b, err := json.MarshalIndent(clog.Response, "", "  ")
@@ -190,6 +298,9 @@ func main() {
 	}
 
 	hn, _ := os.Hostname()
+	if err := cfg.setSpanExporter(hn); err != nil {
+		log.Panic(err)
+	}
 	slog := &data.ServerLog{
 		Server: hn,
 		Iface:  cfg.localhost,
@@ -197,34 +308,94 @@ func main() {
 		Ports:  uint16(cfg.port_https),
 	}
 
+	requests = newHistory(int(cfg.history_size))
+	rules, err := parseRules(cfg.proxy_rules)
+	if err != nil {
+		log.Panic(err)
+	}
+	proxyRules.set(rules)
+	if cfg.upstream != "" {
+		if cfg.upstream_url, err = url.Parse(cfg.upstream); err != nil {
+			log.Panic(err)
+		}
+		if cfg.upstream_url.Scheme == "" || cfg.upstream_url.Host == "" {
+			log.Panicf("bad upstream URL %q", cfg.upstream)
+		}
+		log.Info("Proxying the requests to ", cfg.upstream_url)
+	}
+	if (cfg.upstream != "" || len(cfg.proxy_rules) > 0) && cfg.port_admin == 0 {
+		log.Warn("The proxy rules cannot be changed at runtime: the /rules endpoint needs --port-admin")
+	}
+	if access, err = openAccessLog(cfg.access_log, cfg.access_log_format, cfg.access_log_sample); err != nil {
+		log.Panic(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
 	s := cfg.startHttpServer(client, slog, quit)
 	stls, err := cfg.startHttpsServer(client, p, slog, quit)
 	if err != nil {
 		log.Panicf("failed to start HTTPS server: %v", err)
 	}
+	listeners, err := cfg.startListeners(client, slog, quit)
+	if err != nil {
+		log.Panicf("failed to start the listeners: %v", err)
+	}
+	tlsListeners, err := cfg.startTLSListeners(client, p, slog, quit)
+	if err != nil {
+		log.Panicf("failed to start the TLS listeners: %v", err)
+	}
+	listeners = append(listeners, tlsListeners...)
+	admin := cfg.startAdminServer(quit)
+	tcpEcho := cfg.startTcpEcho(quit)
+	udpEcho := cfg.startUdpEcho(quit)
 
-	switch <-quit {
-	case 1:
-		log.Info("Shutting down")
-		err := s.Shutdown(context.Background())
-		if err != nil {
-			log.Error("Error:", err)
-		}
-		<-quit
-		if stls != nil {
-			err = stls.Shutdown(context.Background())
-			if err != nil {
-				log.Error("Error:", err)
-			}
-			<-quit
+	var delay time.Duration
+	select {
+	case sig := <-signals:
+		log.Info("Received ", sig)
+		delay = cfg.shutdown_delay
+	case delay = <-stop:
+	case c := <-quit:
+		if c == 2 {
+			log.Panic("Rabbits are coming!")
 		}
+		log.Error("A server has stopped")
+	}
+	if err := cfg.shutdown(delay, append([]*http.Server{s, stls}, listeners...), admin); err != nil {
+		log.Panic("Failed to stop gracefully: ", err)
+	}
+	if tcpEcho != nil {
+		tcpEcho.Close()
+	}
+	if udpEcho != nil {
+		udpEcho.Close()
+	}
+	if err := tracing.Shutdown(); err != nil {
+		log.Error("Error:", err)
+	}
+	log.Info("Exiting normally")
+}
+
+func (cfg *config) setSpanExporter(hostname string) error {
+	resource := map[string]any{
+		"service.name": "hop",
+		"host.name":    hostname,
+	}
+	switch {
+	case cfg.otlp_endpoint != "":
+		tracing.SetExporter(tracing.NewOTLPExporter(cfg.otlp_endpoint, resource))
+		log.Info("Exporting spans to ", cfg.otlp_endpoint)
+	case cfg.otlp_file != "":
+		e, err := tracing.NewFileExporter(cfg.otlp_file, resource)
 		if err != nil {
-			log.Panic("Failed to stop gracefully")
+			return err
 		}
-	case 2:
-		log.Panic("Rabbits are coming!")
+		tracing.SetExporter(e)
+		log.Info("Exporting spans to ", cfg.otlp_file)
 	}
-	log.Info("Exiting normally")
+	return nil
 }
 
 func (cfg *config) getCert(cn string) *tls.Certificate {
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// logSpec describes the lines written by the -log command.
+type logSpec struct {
+	level  log.Level
+	msg    string
+	n      int
+	size   int
+	format string
+	to     string
+}
+
+// The limits of the -log entries, and of their total size.
+const (
+	maxLogEntries = 10000
+	maxLogSize    = 1 << 20
+	maxLogTotal   = 4 << 20
+)
+
+// logMu keeps the crafted entries, multi-line ones especially, from
+// interleaving.
+var logMu sync.Mutex
+
+// parseLogArgs parses the -log command arguments, e.g.
+// "level=warn,msg=hello,n=100,size=2k,format=json,to=stderr".
+func parseLogArgs(args string) (*logSpec, error) {
+	s := &logSpec{level: log.InfoLevel, msg: "hop log entry", n: 1, format: "text", to: "stdout"}
+	if args == "" {
+		return s, nil
+	}
+	for _, p := range strings.Split(args, ",") {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("missing value of %q", p)
+		}
+		var err error
+		switch kv[0] {
+		case "level":
+			s.level, err = log.ParseLevel(kv[1])
+		case "msg":
+			s.msg, err = url.PathUnescape(kv[1])
+		case "n":
+			if s.n, err = strconv.Atoi(kv[1]); err == nil && (s.n < 0 || s.n > maxLogEntries) {
+				err = fmt.Errorf("n %d is not within [0, %d]", s.n, maxLogEntries)
+			}
+		case "size":
+			if s.size, err = parseSize(kv[1]); err == nil && (s.size < 0 || s.size > maxLogSize) {
+				err = fmt.Errorf("size %s is not within [0, 1m]", kv[1])
+			}
+		case "format":
+			if kv[1] != "json" && kv[1] != "text" && kv[1] != "multiline" {
+				err = fmt.Errorf("unknown log format %q", kv[1])
+			}
+			s.format = kv[1]
+		case "to":
+			if kv[1] != "stdout" && kv[1] != "stderr" {
+				err = fmt.Errorf("unknown log destination %q", kv[1])
+			}
+			s.to = kv[1]
+		default:
+			err = fmt.Errorf("unexpected argument %q", p)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if total := s.n * max(s.size, len(s.msg)); total > maxLogTotal {
+		return nil, fmt.Errorf("%d entries of %d bytes exceed the %d bytes in total", s.n, total/s.n, maxLogTotal)
+	}
+	return s, nil
+}
+
+// parseSize parses a number of bytes with an optional k or m suffix.
+func parseSize(s string) (int, error) {
+	m := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		m = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		m = 1 << 20
+	}
+	if m > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	return n * m, err
+}
+
+// message returns the message, padded up to the size.
+func (s *logSpec) message() string {
+	if len(s.msg) >= s.size {
+		return s.msg
+	}
+	return s.msg + " " + strings.Repeat("X", s.size-len(s.msg)-1)
+}
+
+// entry formats the i-th entry, with the trailing new line.
+func (s *logSpec) entry(i int, now time.Time) ([]byte, error) {
+	if s.format == "multiline" {
+		return []byte(stackEntry(s.level, s.message(), i, now)), nil
+	}
+	e := &log.Entry{
+		Logger:  log.StandardLogger(),
+		Data:    log.Fields{"seq": i, "source": "hop"},
+		Time:    now,
+		Level:   s.level,
+		Message: s.message(),
+	}
+	if s.format == "json" {
+		return (&log.JSONFormatter{TimestampFormat: time.RFC3339Nano}).Format(e)
+	}
+	return (&log.TextFormatter{DisableColors: true, FullTimestamp: true, TimestampFormat: time.RFC3339Nano}).Format(e)
+}
+
+// stackEntry formats an entry followed by a Go panic like stack trace.
+func stackEntry(level log.Level, msg string, i int, now time.Time) string {
+	lines := []string{
+		fmt.Sprintf("%s %s %s (seq=%d)", now.Format(time.RFC3339Nano), strings.ToUpper(level.String()), msg, i),
+		"goroutine 1 [running]:",
+		"main.step(0xc000010000, {0xc000012000, 0x4})",
+		"\t/hop/commands.go:123 +0x1d",
+		"main.makeReq(0xc000020000, 0xc000030000)",
+		"\t/hop/commands.go:42 +0x2b",
+		"main.(*hopHandler).ServeHTTP(0xc000040000, {0x7f0000, 0xc000050000}, 0xc000030000)",
+		"\t/hop/server.go:97 +0x3c",
+		"net/http.serverHandler.ServeHTTP({0xc000060000}, {0x7f0000, 0xc000050000}, 0xc000030000)",
+		"\t/usr/local/go/src/net/http/server.go:2936 +0x316",
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// write writes the entries and returns the number of bytes written.
+func (s *logSpec) write(w io.Writer) (int, error) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	total := 0
+	for i := 1; i <= s.n; i++ {
+		b, err := s.entry(i, time.Now())
+		if err != nil {
+			return total, err
+		}
+		n, err := w.Write(b)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (s *logSpec) writer() io.Writer {
+	if s.to == "stderr" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/0x656b694d/hop/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounter("hop_requests_total",
+		"Handled requests by method and response code.", "method", "code")
+	commandsTotal = metrics.NewCounter("hop_commands_total",
+		"Executed commands.", "command")
+	faultsTotal = metrics.NewCounter("hop_faults_total",
+		"Injected faults by command.", "fault")
+	requestsInFlight = metrics.NewGauge("hop_requests_in_flight",
+		"Requests being handled.")
+	requestDuration = metrics.NewHistogram("hop_request_duration_seconds",
+		"Handling time of the incoming requests.", metrics.DefBuckets, "method")
+	downstreamTotal = metrics.NewCounter("hop_downstream_requests_total",
+		"Requests to the next hops by target and response code.", "target", "code")
+	downstreamDuration = metrics.NewHistogram("hop_downstream_duration_seconds",
+		"Latency of the requests to the next hops by target.", metrics.DefBuckets, "target")
+
+	registry = metrics.NewRegistry(
+		requestsTotal,
+		commandsTotal,
+		faultsTotal,
+		requestsInFlight,
+		requestDuration,
+		downstreamTotal,
+		downstreamDuration,
+	)
+)
+
+// methods are the method label values, the other methods are "OTHER".
+var methods = map[string]struct{}{
+	http.MethodGet: {}, http.MethodHead: {}, http.MethodPost: {}, http.MethodPut: {},
+	http.MethodPatch: {}, http.MethodDelete: {}, http.MethodConnect: {},
+	http.MethodOptions: {}, http.MethodTrace: {},
+}
+
+// methodLabel bounds the method label to the standard methods.
+func methodLabel(method string) string {
+	if _, ok := methods[method]; ok {
+		return method
+	}
+	return "OTHER"
+}
+
+// labelSet bounds the values of a label: the first max values are kept, the
+// following ones are counted as "other".
+type labelSet struct {
+	mu     sync.Mutex
+	max    int
+	values map[string]struct{}
+}
+
+// targets are the target label values.
+var targets = &labelSet{max: 100, values: map[string]struct{}{}}
+
+func (s *labelSet) label(value string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[value]; ok {
+		return value
+	}
+	if len(s.values) >= s.max {
+		return "other"
+	}
+	s.values[value] = struct{}{}
+	return value
+}
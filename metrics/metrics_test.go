@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	c := NewCounter("test_total", "Test counter.", "method", "code")
+	c.Inc("GET", "200")
+	c.Inc("GET", "200")
+	c.Add(0.5, "PO\"ST", "500")
+	g := NewGauge("test_in_flight", "Test gauge.")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h := NewHistogram("test_seconds", "Test histogram.", []float64{0.1, 1}, "target")
+	h.Observe(0.05, "a:80")
+	h.Observe(0.5, "a:80")
+	h.Observe(5, "a:80")
+
+	rec := httptest.NewRecorder()
+	NewRegistry(c, g, h).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, strings.Join([]string{
+		"# HELP test_total Test counter.",
+		"# TYPE test_total counter",
+		`test_total{method="GET",code="200"} 2`,
+		`test_total{method="PO\"ST",code="500"} 0.5`,
+		"# HELP test_in_flight Test gauge.",
+		"# TYPE test_in_flight gauge",
+		"test_in_flight 1",
+		"# HELP test_seconds Test histogram.",
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{target="a:80",le="0.1"} 1`,
+		`test_seconds_bucket{target="a:80",le="1"} 2`,
+		`test_seconds_bucket{target="a:80",le="+Inf"} 3`,
+		`test_seconds_sum{target="a:80"} 5.55`,
+		`test_seconds_count{target="a:80"} 3`,
+		"",
+	}, "\n"), rec.Body.String())
+}
+
+func TestLabelsMismatch(t *testing.T) {
+	c := NewCounter("test_total", "Test counter.", "method")
+	assert.Panics(t, func() { c.Inc() })
+}
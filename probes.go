@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probe is a health state which can be changed at runtime, for a while or
+// for good.
+type probe struct {
+	name string
+	mu   sync.Mutex
+	ok   bool
+	// base is the state set for good, restored after a temporary one.
+	base  bool
+	timer *time.Timer
+}
+
+var (
+	readiness = newProbe("ready")
+	liveness  = newProbe("live")
+)
+
+func newProbe(name string) *probe {
+	return &probe{name: name, ok: true, base: true}
+}
+
+// set changes the state. With a positive duration, the state set for good
+// is restored after it.
+func (p *probe) set(ok bool, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.ok = ok
+	if d <= 0 {
+		p.base = ok
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		// A stopped timer may have fired already, waiting for the lock.
+		if p.timer != timer {
+			return
+		}
+		p.ok = p.base
+		p.timer = nil
+	})
+	p.timer = timer
+}
+
+func (p *probe) get() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ok
+}
+
+func (p *probe) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	probeResponse(w, p)
+}
+
+// healthz is healthy when all the probes are.
+func healthz(w http.ResponseWriter, req *http.Request) {
+	probeResponse(w, liveness, readiness)
+}
+
+func probeResponse(w http.ResponseWriter, probes ...*probe) {
+	code := http.StatusOK
+	lines := make([]string, 0, len(probes))
+	for _, p := range probes {
+		state := "ok"
+		if !p.get() {
+			state = "failing"
+			code = http.StatusServiceUnavailable
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", p.name, state))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, strings.Join(lines, "\n"))
+}
+
+// parseProbeArgs parses the arguments of the probe commands: the state and
+// an optional duration, e.g. "false,for=30s". A duration without a unit is
+// in milliseconds, as for -wait.
+func parseProbeArgs(args string) (bool, time.Duration, error) {
+	parts := strings.Split(args, ",")
+	ok, err := strconv.ParseBool(parts[0])
+	if err != nil {
+		return false, 0, err
+	}
+	var d time.Duration
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || kv[0] != "for" {
+			return false, 0, fmt.Errorf("unexpected argument %q", p)
+		}
+		if d, err = parseDuration(kv[1]); err != nil {
+			return false, 0, err
+		}
+	}
+	return ok, d, nil
+}
+
+// parseDuration parses a Go duration or a number of milliseconds.
+func parseDuration(s string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(s); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,268 @@
+// Package proxyproto reads and writes the PROXY protocol headers, versions 1
+// and 2, as described in
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	signatureV1 = []byte("PROXY ")
+	signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	ErrBadHeader = errors.New("bad PROXY protocol header")
+)
+
+const (
+	maxV1Length = 107
+
+	commandLocal = 0x0
+	commandProxy = 0x1
+
+	familyInet  = 0x1
+	familyInet6 = 0x2
+	familyUnix  = 0x3
+
+	protoStream = 0x1
+	protoDgram  = 0x2
+)
+
+// Header is a PROXY protocol header. Without the addresses, the connection
+// is not proxied (v1 UNKNOWN, v2 LOCAL).
+type Header struct {
+	Version     int
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// Read reads the header, if the reader starts with a signature. It returns a
+// nil header otherwise.
+func Read(r *bufio.Reader) (*Header, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch b[0] {
+	case signatureV1[0]:
+		if b, err = r.Peek(len(signatureV1)); err == nil && bytes.Equal(b, signatureV1) {
+			return readV1(r)
+		}
+	case signatureV2[0]:
+		if b, err = r.Peek(len(signatureV2)); err == nil && bytes.Equal(b, signatureV2) {
+			return readV2(r)
+		}
+	}
+	return nil, nil
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < maxV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: no CRLF in %d bytes", ErrBadHeader, len(line))
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	h := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrBadHeader, line)
+	}
+	var err error
+	if h.Source, err = tcpAddr(fields[2], fields[4]); err != nil {
+		return nil, err
+	}
+	if h.Destination, err = tcpAddr(fields[3], fields[5]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func tcpAddr(ip, port string) (*net.TCPAddr, error) {
+	a := net.ParseIP(ip)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if a == nil || err != nil {
+		return nil, fmt.Errorf("%w: address %s port %s", ErrBadHeader, ip, port)
+	}
+	return &net.TCPAddr{IP: a, Port: int(p)}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: version %d", ErrBadHeader, fixed[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	h := &Header{Version: 2}
+	switch fixed[12] & 0xf {
+	case commandLocal:
+		return h, nil
+	case commandProxy:
+	default:
+		return nil, fmt.Errorf("%w: command %d", ErrBadHeader, fixed[12]&0xf)
+	}
+	family, proto := fixed[13]>>4, fixed[13]&0xf
+	addr := func(ip []byte, port []byte) net.Addr {
+		p := int(binary.BigEndian.Uint16(port))
+		if proto == protoDgram {
+			return &net.UDPAddr{IP: net.IP(ip), Port: p}
+		}
+		return &net.TCPAddr{IP: net.IP(ip), Port: p}
+	}
+	switch {
+	case family == familyInet && len(payload) >= 12:
+		h.Source = addr(payload[0:4], payload[8:10])
+		h.Destination = addr(payload[4:8], payload[10:12])
+	case family == familyInet6 && len(payload) >= 36:
+		h.Source = addr(payload[0:16], payload[32:34])
+		h.Destination = addr(payload[16:32], payload[34:36])
+	case family == familyUnix && len(payload) >= 216:
+		h.Source = &net.UnixAddr{Name: cString(payload[0:108]), Net: "unix"}
+		h.Destination = &net.UnixAddr{Name: cString(payload[108:216]), Net: "unix"}
+	default:
+		// Unspecified family: the addresses are ignored.
+	}
+	return h, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Format formats the header. The addresses must be both of the same IP
+// family, or the header is formatted as not proxied.
+func (h *Header) Format() []byte {
+	src, _ := h.Source.(*net.TCPAddr)
+	dst, _ := h.Destination.(*net.TCPAddr)
+	ipv4 := src != nil && dst != nil && src.IP.To4() != nil && dst.IP.To4() != nil
+	ipv6 := src != nil && dst != nil && !ipv4 && src.IP.To4() == nil && dst.IP.To4() == nil
+	if h.Version == 1 {
+		switch {
+		case ipv4:
+			return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", src.IP.To4(), dst.IP.To4(), src.Port, dst.Port))
+		case ipv6:
+			return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", src.IP, dst.IP, src.Port, dst.Port))
+		}
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	b := append([]byte{}, signatureV2...)
+	var payload []byte
+	switch {
+	case ipv4:
+		b = append(b, 0x20|commandProxy, familyInet<<4|protoStream)
+		payload = append(append(payload, src.IP.To4()...), dst.IP.To4()...)
+	case ipv6:
+		b = append(b, 0x20|commandProxy, familyInet6<<4|protoStream)
+		payload = append(append(payload, src.IP.To16()...), dst.IP.To16()...)
+	default:
+		b = append(b, 0x20|commandLocal, 0)
+		return binary.BigEndian.AppendUint16(b, 0)
+	}
+	payload = binary.BigEndian.AppendUint16(payload, uint16(src.Port))
+	payload = binary.BigEndian.AppendUint16(payload, uint16(dst.Port))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	return append(b, payload...)
+}
+
+// Conn reads the header on the first use, and reports the addresses of the
+// header, if any.
+type Conn struct {
+	net.Conn
+	timeout time.Duration
+	r       *bufio.Reader
+	once    sync.Once
+	header  *Header
+	err     error
+}
+
+// NewConn wraps a connection, which may start with a header.
+func NewConn(c net.Conn, timeout time.Duration) *Conn {
+	return &Conn{Conn: c, timeout: timeout, r: bufio.NewReader(c)}
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.header, c.err = Read(c.r)
+	})
+}
+
+// Header returns the header, or nil if the connection hasn't started with
+// one.
+func (c *Conn) Header() (*Header, error) {
+	c.readHeader()
+	return c.header, c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the source address of the header, if any.
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address of the header, if any.
+func (c *Conn) LocalAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// Listener accepts the connections which may start with a header. The
+// header is read by the connection, so that a slow client doesn't block the
+// others.
+type Listener struct {
+	net.Listener
+	Timeout time.Duration
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c, l.Timeout), nil
+}
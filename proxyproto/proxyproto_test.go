@@ -0,0 +1,93 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.2"), Port: 80}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+
+	cases := map[string]struct {
+		input    []byte
+		src, dst string
+		version  int
+	}{
+		"v1 tcp4":    {[]byte("PROXY TCP4 192.0.2.1 198.51.100.2 1234 80\r\n"), "192.0.2.1:1234", "198.51.100.2:80", 1},
+		"v1 tcp6":    {[]byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 443\r\n"), "[2001:db8::1]:1234", "[2001:db8::2]:443", 1},
+		"v1 unknown": {[]byte("PROXY UNKNOWN\r\n"), "", "", 1},
+		"v1 format":  {(&Header{Version: 1, Source: src, Destination: dst}).Format(), "192.0.2.1:1234", "198.51.100.2:80", 1},
+		"v2 ipv4":    {(&Header{Version: 2, Source: src, Destination: dst}).Format(), "192.0.2.1:1234", "198.51.100.2:80", 2},
+		"v2 ipv6":    {(&Header{Version: 2, Source: src6, Destination: dst6}).Format(), "[2001:db8::1]:1234", "[2001:db8::2]:443", 2},
+		"v2 local":   {(&Header{Version: 2}).Format(), "", "", 2},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(c.input), strings.NewReader("GET / HTTP/1.1\r\n")))
+			h, err := Read(r)
+			require.NoError(t, err)
+			require.NotNil(t, h)
+			assert.Equal(t, c.version, h.Version)
+			if c.src == "" {
+				assert.Nil(t, h.Source)
+				assert.Nil(t, h.Destination)
+			} else {
+				assert.Equal(t, c.src, h.Source.String())
+				assert.Equal(t, c.dst, h.Destination.String())
+			}
+			rest, _ := r.ReadString('\n')
+			assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+		})
+	}
+
+	h, err := Read(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n")))
+	assert.NoError(t, err)
+	assert.Nil(t, h)
+
+	for _, bad := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.2 1234\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.2 1234 99999\r\n",
+		"PROXY TCP4 x 198.51.100.2 1234 80\r\n",
+		"PROXY " + strings.Repeat("X", 200),
+	} {
+		_, err := Read(bufio.NewReader(strings.NewReader(bad)))
+		assert.ErrorIs(t, err, ErrBadHeader, bad)
+	}
+}
+
+func TestListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	pl := &Listener{Listener: l}
+	defer pl.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		h := &Header{Version: 2,
+			Source:      &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+			Destination: &net.TCPAddr{IP: net.ParseIP("198.51.100.2"), Port: 80}}
+		c.Write(append(h.Format(), "hello"...))
+	}()
+	c, err := pl.Accept()
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, "192.0.2.1:1234", c.RemoteAddr().String())
+	assert.Equal(t, "198.51.100.2:80", c.LocalAddr().String())
+	b, err := io.ReadAll(c)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
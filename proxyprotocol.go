@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/0x656b694d/hop/proxyproto"
+)
+
+type (
+	// connKey is the request context key of the server connection.
+	connKey struct{}
+	// proxyHeaderKey is the request context key of the PROXY protocol
+	// header to send on the new client connection.
+	proxyHeaderKey struct{}
+)
+
+// newListener listens on the address, accepting the PROXY protocol header if
+// enabled.
+func (cfg *config) newListener(network, addr string) (net.Listener, error) {
+	l, err := net.Listen(network, addr)
+	if err != nil || !cfg.proxy_protocol {
+		return l, err
+	}
+	return &proxyproto.Listener{Listener: l, Timeout: 10 * time.Second}, nil
+}
+
+func saveConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// proxyHeader returns the PROXY protocol header of the request connection,
+// if any, and the address of the proxy.
+func proxyHeader(req *http.Request) (*proxyproto.Header, net.Addr) {
+	conn, _ := req.Context().Value(connKey{}).(net.Conn)
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	c, ok := conn.(*proxyproto.Conn)
+	if !ok {
+		return nil, nil
+	}
+	if h, _ := c.Header(); h != nil {
+		return h, c.Conn.RemoteAddr()
+	}
+	return nil, nil
+}
+
+// parseProxyProtoArgs parses the -proxyproto command arguments, e.g.
+// "v2,src=192.0.2.1:1234". The source address is the address of the client
+// by default, or the local address of the connection to the next hop if the
+// client address is not a TCP one, as on a unix socket.
+func parseProxyProtoArgs(args string, req *http.Request) (*proxyproto.Header, error) {
+	parts := strings.Split(args, ",")
+	h := &proxyproto.Header{}
+	switch parts[0] {
+	case "v1":
+		h.Version = 1
+	case "v2":
+		h.Version = 2
+	default:
+		return nil, fmt.Errorf("unknown PROXY protocol version %q", parts[0])
+	}
+	var src string
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || kv[0] != "src" {
+			return nil, fmt.Errorf("unexpected argument %q", p)
+		}
+		src = kv[1]
+	}
+	if src == "" {
+		if addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr); err == nil {
+			h.Source = addr
+		}
+		return h, nil
+	}
+	addr, err := net.ResolveTCPAddr("tcp", src)
+	if err != nil {
+		return nil, err
+	}
+	h.Source = addr
+	return h, nil
+}
+
+// sendProxyHeader writes the header of the context, if any, on the new
+// connection. The addresses of the connection are used if not set.
+func sendProxyHeader(ctx context.Context, conn net.Conn) error {
+	h, ok := ctx.Value(proxyHeaderKey{}).(*proxyproto.Header)
+	if !ok {
+		return nil
+	}
+	header := *h
+	if header.Source == nil {
+		header.Source = conn.LocalAddr()
+	}
+	header.Destination = conn.RemoteAddr()
+	_, err := conn.Write(header.Format())
+	return err
+}
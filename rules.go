@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// proxyRule injects faults in the proxied requests it matches: a delay, an
+// abort with a code, a connection reset, rewritten request and response
+// headers, or a truncated response body.
+type proxyRule struct {
+	host     string
+	prefix   string
+	method   string
+	percent  int
+	delay    time.Duration
+	code     int
+	reset    bool
+	headers  []header
+	rheaders []header
+	truncate int64
+}
+
+// header is a header to set, or to remove if the value is empty.
+type header struct {
+	name, value string
+}
+
+func parseHeader(s string) (header, error) {
+	s, err := url.PathUnescape(s)
+	if err != nil {
+		return header{}, err
+	}
+	name, value, _ := strings.Cut(s, ":")
+	if name == "" {
+		return header{}, fmt.Errorf("missing header name in %q", s)
+	}
+	return header{http.CanonicalHeaderKey(name), value}, nil
+}
+
+// headerEscaper escapes the header values as parseHeader expects them: the
+// commas, which separate the rule arguments, the spaces, which separate the
+// rules of the /rules endpoint, and the percent signs.
+var headerEscaper = strings.NewReplacer("%", "%25", ",", "%2C", " ", "%20", "\t", "%09")
+
+func (h header) String() string {
+	return h.name + ":" + headerEscaper.Replace(h.value)
+}
+
+func (h header) apply(headers http.Header) {
+	if h.value == "" {
+		headers.Del(h.name)
+	} else {
+		headers.Set(h.name, h.value)
+	}
+}
+
+// parseProxyRule parses a rule, e.g. "host=api,method=GET,delay=100ms,code=503,percent=50"
+// or "prefix=/api,header=X-User:test,rheader=Cache-Control:,truncate=100".
+func parseProxyRule(s string) (*proxyRule, error) {
+	rule := &proxyRule{percent: 100, truncate: -1}
+	for _, p := range strings.Split(s, ",") {
+		kv := strings.SplitN(p, "=", 2)
+		if kv[0] == "reset" && len(kv) == 1 {
+			rule.reset = true
+			continue
+		}
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("missing value of %q", p)
+		}
+		var err error
+		switch kv[0] {
+		case "host":
+			rule.host = kv[1]
+		case "prefix":
+			rule.prefix = kv[1]
+		case "method":
+			rule.method = strings.ToUpper(kv[1])
+		case "percent":
+			if rule.percent, err = strconv.Atoi(kv[1]); err == nil && (rule.percent < 0 || rule.percent > 100) {
+				err = fmt.Errorf("percent %d is not within [0, 100]", rule.percent)
+			}
+		case "delay":
+			rule.delay, err = parseDuration(kv[1])
+		case "code":
+			if rule.code, err = strconv.Atoi(kv[1]); err == nil && (rule.code < 100 || rule.code > 999) {
+				err = fmt.Errorf("bad code %d", rule.code)
+			}
+		case "reset":
+			rule.reset, err = strconv.ParseBool(kv[1])
+		case "header", "rheader":
+			var h header
+			if h, err = parseHeader(kv[1]); err == nil {
+				if kv[0] == "header" {
+					rule.headers = append(rule.headers, h)
+				} else {
+					rule.rheaders = append(rule.rheaders, h)
+				}
+			}
+		case "truncate":
+			if rule.truncate, err = strconv.ParseInt(kv[1], 10, 64); err == nil && rule.truncate < 0 {
+				err = fmt.Errorf("negative truncate %d", rule.truncate)
+			}
+		default:
+			err = fmt.Errorf("unexpected argument %q", p)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rule, nil
+}
+
+func (rule *proxyRule) String() string {
+	parts := []string{}
+	add := func(k, v string) {
+		if v != "" {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	add("host", rule.host)
+	add("prefix", rule.prefix)
+	add("method", rule.method)
+	if rule.percent != 100 {
+		add("percent", strconv.Itoa(rule.percent))
+	}
+	if rule.delay > 0 {
+		add("delay", rule.delay.String())
+	}
+	if rule.code != 0 {
+		add("code", strconv.Itoa(rule.code))
+	}
+	if rule.reset {
+		parts = append(parts, "reset")
+	}
+	for _, h := range rule.headers {
+		add("header", h.String())
+	}
+	for _, h := range rule.rheaders {
+		add("rheader", h.String())
+	}
+	if rule.truncate >= 0 {
+		add("truncate", strconv.FormatInt(rule.truncate, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+// match tells if the rule applies to the request to the host, with the
+// rule probability.
+func (rule *proxyRule) match(req *http.Request, host string) bool {
+	return strings.Contains(host, rule.host) &&
+		strings.HasPrefix(req.URL.Path, rule.prefix) &&
+		(rule.method == "" || rule.method == req.Method) &&
+		rule.percent > rand.Intn(100)
+}
+
+// ruleSet is a list of rules, of which the first matching one applies.
+type ruleSet struct {
+	mu    sync.Mutex
+	rules []*proxyRule
+}
+
+var proxyRules = &ruleSet{}
+
+// parseRules parses the rules, one per string.
+func parseRules(specs []string) ([]*proxyRule, error) {
+	rules := make([]*proxyRule, 0, len(specs))
+	for _, s := range specs {
+		rule, err := parseProxyRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad rule %q: %w", s, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// set replaces the rules and returns their number.
+func (rs *ruleSet) set(rules []*proxyRule) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.rules = rules
+	return len(rs.rules)
+}
+
+// add appends the rules and returns the number of rules now.
+func (rs *ruleSet) add(rules []*proxyRule) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.rules = append(rs.rules, rules...)
+	return len(rs.rules)
+}
+
+func (rs *ruleSet) list() []*proxyRule {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]*proxyRule{}, rs.rules...)
+}
+
+func (rs *ruleSet) match(req *http.Request, host string) *proxyRule {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, rule := range rs.rules {
+		if rule.match(req, host) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// serveRules lists the rules, one per line. PUT replaces them with the rules
+// of the body, one per line, POST appends them, and DELETE removes them all.
+func serveRules(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut, http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(req.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rules, err := parseRules(strings.Fields(string(body)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var n int
+		if req.Method == http.MethodPut {
+			n = proxyRules.set(rules)
+		} else {
+			n = proxyRules.add(rules)
+		}
+		log.Warnf("Changed the proxy rules: %d rules now", n)
+	case http.MethodDelete:
+		proxyRules.set(nil)
+		log.Warn("Removed the proxy rules")
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, rule := range proxyRules.list() {
+		fmt.Fprintln(w, rule)
+	}
+}
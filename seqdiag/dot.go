@@ -0,0 +1,124 @@
+package seqdiag
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0x656b694d/hop/data"
+)
+
+type graph struct {
+	nodes []string
+	known map[string]bool
+	edges []string
+}
+
+// TranslateDot renders the server log as a Graphviz DOT call graph: the nodes
+// are the servers and the edges are the calls between them.
+func TranslateDot(sr *data.ServerLog) (string, error) {
+	if sr == nil {
+		return "", nil
+	}
+	g := &graph{known: map[string]bool{}}
+	g.translate(sr)
+
+	output := make([]string, 0, len(g.nodes)+len(g.edges)+3)
+	output = append(output, "digraph hop {", "  node [shape=box];")
+	output = append(output, g.nodes...)
+	output = append(output, g.edges...)
+	output = append(output, "}")
+
+	return strings.Join(output, "\n"), nil
+}
+
+func (g *graph) node(name string) string {
+	q := dotQuote(name)
+	if !g.known[name] {
+		g.known[name] = true
+		g.nodes = append(g.nodes, fmt.Sprintf("  %s;", q))
+	}
+	return q
+}
+
+func (g *graph) edge(from, to, label string) {
+	g.edges = append(g.edges, fmt.Sprintf("  %s -> %s [label=%s];", from, to, dotQuote(label)))
+}
+
+func (g *graph) translate(sr *data.ServerLog) {
+	srv := g.node(sr.Server)
+	req := sr.Request
+	if req == nil {
+		return
+	}
+	if req.From != "" {
+		g.edge(g.node(req.From), srv, strings.TrimSpace(req.Method+" "+req.Path))
+	}
+	g.calls(srv, req)
+}
+
+func (g *graph) calls(srv string, req *data.RequestLog) {
+	for _, c := range req.Process {
+		if c.Url == "" {
+			if c.Dial != "" {
+				g.edge(srv, g.node(dialTarget(c.Dial)), probeLabel(c))
+			}
+			continue
+		}
+		var callee string
+		if c.Response != nil {
+			callee = g.node(c.Response.Server)
+		} else if u, err := url.Parse(c.Url); err == nil && u.Host != "" {
+			callee = g.node(u.Host)
+		} else {
+			callee = g.node(c.Url)
+		}
+		g.edge(srv, callee, callLabel(c))
+		if c.Response != nil && c.Response.Request != nil {
+			g.calls(callee, c.Response.Request)
+		}
+	}
+}
+
+// callLabel describes a call with its method, status and latency.
+func callLabel(c *data.CommandLog) string {
+	parts := make([]string, 0, 3)
+	if c.Method != "" {
+		parts = append(parts, c.Method)
+	}
+	if c.Code != 0 {
+		parts = append(parts, strconv.FormatUint(uint64(c.Code), 10))
+	} else if c.Error != "" {
+		parts = append(parts, "error")
+	}
+	if c.Latency != 0 {
+		parts = append(parts, formatLatency(c.Latency))
+	}
+	return strings.Join(parts, " ")
+}
+
+// probeLabel describes a -tcp or -udp probe with its network, outcome and
+// latency.
+func probeLabel(c *data.CommandLog) string {
+	parts := []string{strings.SplitN(c.Dial, ":", 2)[0], "ok"}
+	if c.Error != "" {
+		parts[1] = "error"
+	}
+	if c.Latency != 0 {
+		parts = append(parts, formatLatency(c.Latency))
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatLatency(d time.Duration) string {
+	if d >= time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+func dotQuote(s string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(s) + "\""
+}
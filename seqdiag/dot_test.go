@@ -0,0 +1,54 @@
+package seqdiag
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x656b694d/hop/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslateDot(t *testing.T) {
+	sr := &data.ServerLog{
+		Server: "a",
+		Request: &data.RequestLog{
+			Method: "GET",
+			Path:   "/b/c",
+			From:   "10.0.0.1:5555",
+			Process: []*data.CommandLog{
+				{
+					Command: "hop", Method: "GET", Url: "http://b/c", Code: 200, Latency: 1500 * time.Microsecond,
+					Response: &data.ServerLog{
+						Server: "b",
+						Request: &data.RequestLog{
+							Method: "GET",
+							Path:   "/c",
+							From:   "10.0.0.2:6666",
+							Process: []*data.CommandLog{
+								{Command: "hop", Method: "GET", Url: "http://c:80/", Error: "connection refused"},
+								{Command: "-tcp", Dial: "tcp://db:5432", Latency: 2 * time.Millisecond},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	actual, err := TranslateDot(sr)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"digraph hop {",
+		"  node [shape=box];",
+		`  "a";`,
+		`  "10.0.0.1:5555";`,
+		`  "b";`,
+		`  "c:80";`,
+		`  "db:5432";`,
+		`  "10.0.0.1:5555" -> "a" [label="GET /b/c"];`,
+		`  "a" -> "b" [label="GET 200 1.5ms"];`,
+		`  "b" -> "c:80" [label="GET error"];`,
+		`  "b" -> "db:5432" [label="tcp ok 2ms"];`,
+		"}",
+	}, "\n"), actual)
+}
@@ -0,0 +1,59 @@
+package seqdiag
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/0x656b694d/hop/data"
+)
+
+// Format describes a diagram renderer.
+type Format struct {
+	Name      string
+	MediaType string
+	Translate func(*data.ServerLog) (string, error)
+}
+
+var formats = []*Format{
+	{"seqdiag", "text/seqdiag", Translate},
+	{"mermaid", "text/vnd.mermaid", TranslateMermaid},
+	{"plantuml", "text/x-plantuml", TranslatePlantUML},
+	{"dot", "text/vnd.graphviz", TranslateDot},
+	{"svg", "image/svg+xml", TranslateSVG},
+}
+
+// Names returns the names of the supported formats.
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for _, f := range formats {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// ByName returns the format with the given name or nil.
+func ByName(name string) *Format {
+	for _, f := range formats {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Negotiate returns the first format from the Accept header value, or nil if
+// none of the accepted media types is a diagram.
+func Negotiate(accept string) *Format {
+	for _, part := range strings.Split(accept, ",") {
+		mt, _, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		for _, f := range formats {
+			if f.MediaType == mt {
+				return f
+			}
+		}
+	}
+	return nil
+}
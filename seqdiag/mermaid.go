@@ -0,0 +1,66 @@
+package seqdiag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/0x656b694d/hop/data"
+)
+
+// TranslateMermaid renders the server log as a Mermaid sequenceDiagram.
+//
+// Names like remote addresses contain characters which Mermaid doesn't
+// accept in identifiers, so every participant is declared with a generated
+// identifier and the original name as an alias.
+func TranslateMermaid(sr *data.ServerLog) (string, error) {
+	if sr == nil {
+		return "", nil
+	}
+	s := newSequence(sr)
+
+	output := make([]string, 0, len(s.participants)+len(s.steps)+1)
+	output = append(output, "sequenceDiagram")
+	for i, p := range s.participants {
+		output = append(output, fmt.Sprintf("participant p%d as %s", i, mermaidLabel(p)))
+	}
+	for _, st := range s.steps {
+		text := mermaidText(strings.Join(st.text, "\n"))
+		switch st.kind {
+		case stepNote:
+			output = append(output, fmt.Sprintf("Note over p%d: %s", st.from, text))
+		case stepReply:
+			output = append(output, fmt.Sprintf("p%d-->>p%d: %s", st.from, st.to, text))
+		default:
+			output = append(output, fmt.Sprintf("p%d->>p%d: %s", st.from, st.to, text))
+		}
+	}
+
+	return strings.Join(output, "\n    "), nil
+}
+
+// mermaidText escapes the characters which have a special meaning in
+// Mermaid messages and notes.
+func mermaidText(s string) string {
+	return strings.NewReplacer(
+		"#", "#35;",
+		";", "#59;",
+		"<", "#lt;",
+		">", "#gt;",
+		"\r", "",
+		"\n", "<br/>",
+	).Replace(s)
+}
+
+// mermaidLabel makes the participant alias safe: the alias ends at the first
+// '#' or ';', and entity codes are not decoded there.
+func mermaidLabel(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer(
+		"#", "_",
+		";", "_",
+		"\r", "",
+		"\n", " ",
+	).Replace(s)
+}
@@ -0,0 +1,80 @@
+package seqdiag
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/0x656b694d/hop/data"
+	"github.com/0x656b694d/hop/tools"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslateMermaid(t *testing.T) {
+	tests := map[string]struct {
+		sr *data.ServerLog
+
+		expected []string
+	}{
+		"nil": {},
+		"one participant": {
+			sr: &data.ServerLog{Server: "test"},
+			expected: []string{
+				"sequenceDiagram",
+				"participant p0 as test",
+			},
+		},
+		"ipv6 remote": {
+			sr: &data.ServerLog{
+				Server: "test",
+				Request: &data.RequestLog{
+					Method: "GET",
+					Path:   "/-cmd;x#y",
+					From:   "[::1]:45678",
+					Size:   12,
+					Code:   200,
+					Process: []*data.CommandLog{
+						{Command: "-cmd", Output: tools.ArrLog{"line1", "line2"}},
+					},
+				},
+			},
+			expected: []string{
+				"sequenceDiagram",
+				"participant p0 as test",
+				"participant p1 as [::1]:45678",
+				"p1->>p0: GET /-cmd#59;x#35;y (12 bytes)",
+				"p0->>p0: Command -cmd",
+				"Note over p0: line1<br/>line2",
+				"p0-->>p1: 200 OK",
+			},
+		},
+		"same participant": {
+			sr: &data.ServerLog{
+				Server: "a",
+				Request: &data.RequestLog{
+					Method: "GET",
+					Path:   "/",
+					From:   "a",
+				},
+			},
+			expected: []string{
+				"sequenceDiagram",
+				"participant p0 as a",
+				"p0->>p0: GET / (0 bytes)",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual, err := TranslateMermaid(tt.sr)
+			assert.NoError(t, err)
+			assert.Equal(t, strings.Join(tt.expected, "\n    "), actual)
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	assert.Nil(t, Negotiate(""))
+	assert.Nil(t, Negotiate("application/json"))
+	assert.Equal(t, "seqdiag", Negotiate("text/seqdiag").Name)
+	assert.Equal(t, "mermaid", Negotiate("text/html, text/vnd.mermaid;q=0.9").Name)
+}
@@ -0,0 +1,55 @@
+package seqdiag
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/0x656b694d/hop/data"
+)
+
+// endNote matches the lines which close a note.
+var endNote = regexp.MustCompile(`(?i)^\s*end\s*note\s*$`)
+
+// TranslatePlantUML renders the server log as a PlantUML sequence diagram.
+func TranslatePlantUML(sr *data.ServerLog) (string, error) {
+	if sr == nil {
+		return "", nil
+	}
+	s := newSequence(sr)
+
+	output := make([]string, 0, len(s.participants)+len(s.steps)+2)
+	output = append(output, "@startuml")
+	for i, p := range s.participants {
+		output = append(output, fmt.Sprintf("participant \"%s\" as p%d", strings.ReplaceAll(p, "\"", "'"), i))
+	}
+	for _, st := range s.steps {
+		switch st.kind {
+		case stepNote:
+			output = append(output, fmt.Sprintf("note over p%d", st.from))
+			for _, t := range st.text {
+				for _, line := range strings.Split(t, "\n") {
+					// A line like "end note" or "endnote" would close the
+					// note prematurely. The creole escape ~ is not shown.
+					if endNote.MatchString(line) {
+						line = "~" + strings.TrimSpace(line)
+					}
+					output = append(output, line)
+				}
+			}
+			output = append(output, "end note")
+		case stepReply:
+			output = append(output, fmt.Sprintf("p%d --> p%d : %s", st.from, st.to, plantumlText(st.text[0])))
+		default:
+			output = append(output, fmt.Sprintf("p%d -> p%d : %s", st.from, st.to, plantumlText(st.text[0])))
+		}
+	}
+	output = append(output, "@enduml")
+
+	return strings.Join(output, "\n"), nil
+}
+
+// plantumlText keeps the message on a single line.
+func plantumlText(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "\\n").Replace(s)
+}
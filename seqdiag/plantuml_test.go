@@ -0,0 +1,43 @@
+package seqdiag
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/0x656b694d/hop/data"
+	"github.com/0x656b694d/hop/tools"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslatePlantUML(t *testing.T) {
+	sr := &data.ServerLog{
+		Server: "test",
+		Request: &data.RequestLog{
+			Method: "GET",
+			Path:   "/-cmd",
+			From:   "[::1]:45678",
+			Size:   12,
+			Process: []*data.CommandLog{
+				{Command: "-cmd", Output: tools.ArrLog{"line1", "end note", "endnote", " End  Note ", "ENDNOTE\nend note x"}},
+			},
+		},
+	}
+	actual, err := TranslatePlantUML(sr)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"@startuml",
+		`participant "test" as p0`,
+		`participant "[::1]:45678" as p1`,
+		"p1 -> p0 : GET /-cmd (12 bytes)",
+		"p0 -> p0 : Command -cmd",
+		"note over p0",
+		"line1",
+		"~end note",
+		"~endnote",
+		"~End  Note",
+		"~ENDNOTE",
+		"end note x",
+		"end note",
+		"@enduml",
+	}, "\n"), actual)
+}
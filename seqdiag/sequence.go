@@ -0,0 +1,167 @@
+package seqdiag
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/0x656b694d/hop/data"
+)
+
+type stepKind int
+
+const (
+	stepMessage stepKind = iota
+	stepReply
+	stepNote
+)
+
+// step is a message between two participants or a note over one of them.
+type step struct {
+	kind     stepKind
+	from, to int
+	text     []string
+}
+
+// sequence is a format agnostic sequence diagram, which the renderers
+// translate to their syntax.
+type sequence struct {
+	participants []string
+	index        map[string]int
+	steps        []step
+}
+
+func newSequence(sr *data.ServerLog) *sequence {
+	s := &sequence{index: map[string]int{}}
+	s.translate(sr, -1)
+	return s
+}
+
+func (s *sequence) participant(name string) int {
+	if i, ok := s.index[name]; ok {
+		return i
+	}
+	s.index[name] = len(s.participants)
+	s.participants = append(s.participants, name)
+	return s.index[name]
+}
+
+func (s *sequence) message(kind stepKind, from, to int, text string) {
+	s.steps = append(s.steps, step{kind: kind, from: from, to: to, text: []string{text}})
+}
+
+func (s *sequence) note(over int, text ...string) {
+	s.steps = append(s.steps, step{kind: stepNote, from: over, to: over, text: text})
+}
+
+// translate adds the steps of the server log. The caller is the participant
+// which made the request, or -1 for the first server in the chain, in which
+// case the request comes from its remote address.
+func (s *sequence) translate(sr *data.ServerLog, caller int) {
+	srv := s.participant(sr.Server)
+	req := sr.Request
+	if req == nil {
+		return
+	}
+	root := caller < 0
+	if root {
+		caller = s.participant(req.From)
+		s.message(stepMessage, caller, srv, fmt.Sprintf("%s %s (%d bytes)", req.Method, req.Path, req.Size))
+	}
+	for _, c := range req.Process {
+		if c.Url != "" {
+			s.call(srv, c)
+			continue
+		}
+		if c.Dial != "" {
+			s.probe(srv, c)
+			continue
+		}
+		if c.Command != "" {
+			s.message(stepMessage, srv, srv, "Command "+c.Command)
+		}
+		if len(c.Output) > 0 {
+			s.note(srv, c.Output...)
+		}
+		if c.Error != "" {
+			s.note(srv, c.Error)
+		}
+	}
+	if root && req.Code != 0 {
+		reply := status(req.Code)
+		if req.Duration != 0 {
+			reply += fmt.Sprintf(" (%s)", formatLatency(req.Duration))
+		}
+		s.message(stepReply, srv, caller, reply)
+	}
+}
+
+// call adds a request to the next hop, the steps of the called server and
+// its response.
+func (s *sequence) call(srv int, c *data.CommandLog) {
+	var callee int
+	if c.Response != nil {
+		callee = s.participant(c.Response.Server)
+	} else if u, err := url.Parse(c.Url); err == nil && u.Host != "" {
+		callee = s.participant(u.Host)
+	} else {
+		callee = s.participant(c.Url)
+	}
+	s.message(stepMessage, srv, callee, strings.TrimSpace(c.Method+" "+c.Url))
+	if c.Response != nil {
+		s.translate(c.Response, srv)
+	}
+	var reply string
+	if c.Code != 0 {
+		reply = status(int(c.Code))
+	} else {
+		reply = "error"
+	}
+	if c.Latency != 0 {
+		reply += fmt.Sprintf(" (%s)", formatLatency(c.Latency))
+	}
+	s.message(stepReply, callee, srv, reply)
+	s.outcome(srv, c)
+}
+
+// probe adds a -tcp or -udp check of a non-HTTP target and its outcome.
+func (s *sequence) probe(srv int, c *data.CommandLog) {
+	callee := s.participant(dialTarget(c.Dial))
+	s.message(stepMessage, srv, callee, c.Dial)
+	reply := "ok"
+	if c.Error != "" {
+		reply = "error"
+	}
+	if c.Latency != 0 {
+		reply += fmt.Sprintf(" (%s)", formatLatency(c.Latency))
+	}
+	s.message(stepReply, callee, srv, reply)
+	s.outcome(srv, c)
+}
+
+// outcome adds the notes of the output and of the error of a call or probe.
+func (s *sequence) outcome(srv int, c *data.CommandLog) {
+	if len(c.Output) > 0 {
+		s.note(srv, c.Output...)
+	}
+	// The error of a failed call is usually reported in the output as well.
+	if c.Error != "" && (len(c.Output) == 0 || c.Output[len(c.Output)-1] != c.Error) {
+		s.note(srv, c.Error)
+	}
+}
+
+// dialTarget returns the address of a dial URL, like tcp://host:port.
+func dialTarget(dial string) string {
+	if u, err := url.Parse(dial); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return dial
+}
+
+func status(code int) string {
+	if text := http.StatusText(code); text != "" {
+		return fmt.Sprintf("%d %s", code, text)
+	}
+	return fmt.Sprint(code)
+}
@@ -0,0 +1,151 @@
+package seqdiag
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/0x656b694d/hop/data"
+)
+
+// Layout of the SVG diagram, in pixels. The text is rendered with a monospace
+// font, so its width can be estimated from the number of characters.
+const (
+	svgCharWidth  = 7
+	svgLineHeight = 15
+	svgBoxHeight  = 30
+	svgMargin     = 20
+	svgMinGap     = 140
+	svgMaxChars   = 80
+)
+
+// TranslateSVG renders the server log as a self-contained SVG sequence
+// diagram.
+func TranslateSVG(sr *data.ServerLog) (string, error) {
+	if sr == nil {
+		return "", nil
+	}
+	s := newSequence(sr)
+
+	gap := s.svgGap()
+	x := func(i int) int {
+		return svgMargin + gap/2 + i*gap
+	}
+	width := 2*svgMargin + len(s.participants)*gap
+
+	var body strings.Builder
+	y := svgMargin + svgBoxHeight + 10
+	for _, st := range s.steps {
+		lines := svgLines(st.text)
+		switch {
+		case st.kind == stepNote:
+			w := svgTextWidth(lines) + 10
+			h := len(lines)*svgLineHeight + 10
+			fmt.Fprintf(&body, `<rect x="%d" y="%d" width="%d" height="%d" fill="#ffffcc" stroke="#999999"/>`+"\n",
+				x(st.from)-w/2, y+5, w, h)
+			for i, line := range lines {
+				fmt.Fprintf(&body, `<text x="%d" y="%d">%s</text>`+"\n",
+					x(st.from)-w/2+5, y+5+(i+1)*svgLineHeight, svgText(line))
+			}
+			y += h + 15
+		case st.from == st.to:
+			fmt.Fprintf(&body, `<text x="%d" y="%d">%s</text>`+"\n", x(st.from)+8, y+12, svgText(lines[0]))
+			fmt.Fprintf(&body, `<path d="M%d,%d h30 v12 h-30" fill="none" stroke="black" marker-end="url(#arrow)"/>`+"\n",
+				x(st.from), y+18)
+			y += 40
+		default:
+			dash := ""
+			if st.kind == stepReply {
+				dash = ` stroke-dasharray="6,3"`
+			}
+			fmt.Fprintf(&body, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n",
+				(x(st.from)+x(st.to))/2, y+12, svgText(lines[0]))
+			fmt.Fprintf(&body, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"%s marker-end="url(#arrow)"/>`+"\n",
+				x(st.from), y+18, x(st.to), y+18, dash)
+			y += 30
+		}
+	}
+	height := y + 10 + svgBoxHeight + svgMargin
+
+	var out strings.Builder
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="monospace" font-size="12">`+"\n",
+		width, height, width, height)
+	out.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto"><path d="M0,0 L10,5 L0,10 z"/></marker></defs>` + "\n")
+	fmt.Fprintf(&out, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+	for i, p := range s.participants {
+		fmt.Fprintf(&out, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999999" stroke-dasharray="4,4"/>`+"\n",
+			x(i), svgMargin+svgBoxHeight, x(i), height-svgMargin-svgBoxHeight)
+		for _, top := range []int{svgMargin, height - svgMargin - svgBoxHeight} {
+			fmt.Fprintf(&out, `<rect x="%d" y="%d" width="%d" height="%d" fill="#eeeeff" stroke="black"/>`+"\n",
+				x(i)-gap/2+10, top, gap-20, svgBoxHeight)
+			fmt.Fprintf(&out, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n",
+				x(i), top+svgBoxHeight/2+4, svgText(p))
+		}
+	}
+	out.WriteString(body.String())
+	out.WriteString("</svg>\n")
+	return out.String(), nil
+}
+
+// svgGap returns the distance between the lifelines which fits all the
+// participant names, messages and notes.
+func (s *sequence) svgGap() int {
+	gap := svgMinGap
+	fit := func(w int) {
+		if w > gap {
+			gap = w
+		}
+	}
+	for _, p := range s.participants {
+		fit(svgTextWidth(svgLines([]string{p})) + 40)
+	}
+	for _, st := range s.steps {
+		w := svgTextWidth(svgLines(st.text))
+		switch {
+		case st.kind == stepNote:
+			fit(w + 30)
+		case st.from == st.to:
+			fit(w + 20)
+		default:
+			span := st.to - st.from
+			if span < 0 {
+				span = -span
+			}
+			fit(w/span + 20)
+		}
+	}
+	return gap
+}
+
+// svgLines splits the text into lines, cutting the ones too long to fit.
+func svgLines(text []string) []string {
+	lines := make([]string, 0, len(text))
+	for _, t := range text {
+		for _, line := range strings.Split(strings.ReplaceAll(t, "\r", ""), "\n") {
+			if r := []rune(line); len(r) > svgMaxChars {
+				line = string(r[:svgMaxChars-1]) + "…"
+			}
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+func svgTextWidth(lines []string) int {
+	w := 0
+	for _, line := range lines {
+		if n := len([]rune(line)) * svgCharWidth; n > w {
+			w = n
+		}
+	}
+	return w
+}
+
+func svgText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
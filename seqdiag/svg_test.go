@@ -0,0 +1,64 @@
+package seqdiag
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/0x656b694d/hop/data"
+	"github.com/0x656b694d/hop/tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslateSVG(t *testing.T) {
+	sr := &data.ServerLog{
+		Server: "test",
+		Request: &data.RequestLog{
+			Method: "GET",
+			Path:   "/-cmd",
+			From:   "[::1]:45678",
+			Process: []*data.CommandLog{
+				{Command: "-cmd", Output: tools.ArrLog{"<line1>", strings.Repeat("x", 100)}},
+			},
+		},
+	}
+	actual, err := TranslateSVG(sr)
+	require.NoError(t, err)
+
+	texts := []string{}
+	decoder := xml.NewDecoder(strings.NewReader(actual))
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		switch tok := token.(type) {
+		case xml.StartElement:
+			inText = tok.Name.Local == "text"
+		case xml.CharData:
+			if inText {
+				texts = append(texts, string(tok))
+			}
+		case xml.EndElement:
+			inText = false
+		}
+	}
+	assert.Equal(t, []string{
+		"test", "test",
+		"[::1]:45678", "[::1]:45678",
+		"GET /-cmd (0 bytes)",
+		"Command -cmd",
+		"<line1>",
+		strings.Repeat("x", svgMaxChars-1) + "…",
+	}, texts)
+}
+
+func TestTranslateSVGNil(t *testing.T) {
+	actual, err := TranslateSVG(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, actual)
+}
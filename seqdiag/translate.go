@@ -2,6 +2,7 @@ package seqdiag
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/0x656b694d/hop/data"
@@ -20,38 +21,53 @@ func Translate(sr *data.ServerLog) (string, error) {
 	d.translate(sr)
 
 	output := make([]string, 0, len(d.participants)+len(d.lines))
-	for _, p := range d.participants {
-		output = append(output, fmt.Sprintf("participant %s", p))
+	for i, p := range d.participants {
+		if id := alias(i, p); id != p {
+			output = append(output, fmt.Sprintf("participant \"%s\" as %s", strings.ReplaceAll(p, "\"", "'"), id))
+		} else {
+			output = append(output, fmt.Sprintf("participant %s", p))
+		}
 	}
 	output = append(output, d.lines...)
 
 	return strings.Join(output, "\n"), nil
 }
 
+// plainName matches the participant names which need no alias.
+var plainName = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// alias returns the identifier of the participant in the diagram: the name
+// itself, or a generated one if the name has special characters, like the
+// colons of the remote addresses.
+func alias(i int, name string) string {
+	if plainName.MatchString(name) {
+		return name
+	}
+	return fmt.Sprintf("p%d", i)
+}
+
 func (d *diagram) translate(sr *data.ServerLog) {
-	srv := sr.Server
-
-	d.participants = append(d.participants, srv)
-
-	if req := sr.Request; req != nil {
-		d.participants = append(d.participants, req.From)
-		d.lines = append(d.lines, fmt.Sprintf("%s->%s: %s %s (%d bytes)", req.From, srv, req.Method, req.Path, req.Size))
-		for _, c := range req.Process {
-			if c.Command != "" {
-				d.lines = append(d.lines, fmt.Sprintf("%s->%s: Command %s", srv, srv, c.Command))
-				d.lines = append(d.lines, fmt.Sprintf("note over %s:", srv))
-				d.lines = append(d.lines, c.Output...)
-				d.lines = append(d.lines, "end note")
-			}
-			if c.Url != "" {
-				d.lines = append(d.lines, fmt.Sprintf("%s->%s: Call %s", srv, srv, c.Url))
-			}
-			if c.Error != "" {
-				d.lines = append(d.lines, fmt.Sprintf("note over %s:\n%s\nend note", srv, c.Error))
-			}
-			if c.Response != nil {
-				d.translate(c.Response)
-			}
+	s := newSequence(sr)
+
+	d.participants = append(d.participants, s.participants...)
+	id := func(i int) string {
+		return alias(i, s.participants[i])
+	}
+	for _, st := range s.steps {
+		switch st.kind {
+		case stepNote:
+			d.lines = append(d.lines, fmt.Sprintf("note over %s:", id(st.from)))
+			d.lines = append(d.lines, st.text...)
+			d.lines = append(d.lines, "end note")
+		case stepReply:
+			d.lines = append(d.lines, fmt.Sprintf("%s-->%s: %s", id(st.from), id(st.to), seqdiagText(st.text[0])))
+		default:
+			d.lines = append(d.lines, fmt.Sprintf("%s->%s: %s", id(st.from), id(st.to), seqdiagText(st.text[0])))
 		}
 	}
 }
+
+// seqdiagText keeps the message on a single line.
+func seqdiagText(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "\\n").Replace(s)
+}
@@ -2,6 +2,7 @@ package seqdiag
 
 import (
 	"testing"
+	"time"
 
 	"github.com/0x656b694d/hop/data"
 	"github.com/0x656b694d/hop/tools"
@@ -46,6 +47,101 @@ func Test_diagram_translate(t *testing.T) {
 					"end note"},
 			},
 		},
+		"tcp probe": {
+			sr: &data.ServerLog{
+				Server: "a",
+				Request: &data.RequestLog{
+					Method: "GET",
+					Path:   "/-tcp:db:5432",
+					From:   "localhost",
+					Code:   200,
+					Process: []*data.CommandLog{
+						{Command: "-tcp", Dial: "tcp://db:5432", Latency: 3 * time.Millisecond, Output: tools.ArrLog{"Connected to db:5432"}},
+						{Command: "-udp", Dial: "udp://dns:53", Error: "i/o timeout"},
+					},
+				},
+			},
+			expected: &diagram{
+				participants: []string{"a", "localhost", "db:5432", "dns:53"},
+				lines: []string{
+					"localhost->a: GET /-tcp:db:5432 (0 bytes)",
+					"a->p2: tcp://db:5432",
+					"p2-->a: ok (3ms)",
+					"note over a:",
+					"Connected to db:5432",
+					"end note",
+					"a->p3: udp://dns:53",
+					"p3-->a: error",
+					"note over a:",
+					"i/o timeout",
+					"end note",
+					"a-->localhost: 200 OK",
+				},
+			},
+		},
+		"call chain": {
+			sr: &data.ServerLog{
+				Server: "a",
+				Request: &data.RequestLog{
+					Method: "GET",
+					Path:   "/b/a/-code:404",
+					From:   "10.0.0.1:5555",
+					Code:   404,
+					Process: []*data.CommandLog{
+						{
+							Command: "hop", Method: "GET", Url: "http://b/a/-code:404", Code: 404, Latency: 2 * time.Millisecond,
+							Response: &data.ServerLog{
+								Server: "b",
+								Request: &data.RequestLog{
+									Method: "GET",
+									Path:   "/a/-code:404",
+									From:   "10.0.0.2:6666",
+									Code:   404,
+									Process: []*data.CommandLog{
+										{
+											Command: "hop", Method: "GET", Url: "http://a/-code:404", Code: 404, Latency: time.Millisecond,
+											Response: &data.ServerLog{
+												Server: "a",
+												Request: &data.RequestLog{
+													Method: "GET",
+													Path:   "/-code:404",
+													From:   "10.0.0.3:7777",
+													Code:   404,
+													Process: []*data.CommandLog{
+														{Command: "-code:404", Output: tools.ArrLog{"Returning code 404"}},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						{Command: "hop", Method: "GET", Url: "http://c:80/", Output: tools.ArrLog{"connection refused"}, Error: "connection refused"},
+					},
+				},
+			},
+			expected: &diagram{
+				participants: []string{"a", "10.0.0.1:5555", "b", "c:80"},
+				lines: []string{
+					"p1->a: GET /b/a/-code:404 (0 bytes)",
+					"a->b: GET http://b/a/-code:404",
+					"b->a: GET http://a/-code:404",
+					"a->a: Command -code:404",
+					"note over a:",
+					"Returning code 404",
+					"end note",
+					"a-->b: 404 Not Found (1ms)",
+					"b-->a: 404 Not Found (2ms)",
+					"a->p3: GET http://c:80/",
+					"p3-->a: error",
+					"note over a:",
+					"connection refused",
+					"end note",
+					"a-->p1: 404 Not Found",
+				},
+			},
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
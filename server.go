@@ -1,20 +1,23 @@
 package main
 
 import (
-	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	stdlog "log"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"strconv"
 	"time"
 
 	"github.com/0x656b694d/hop/data"
+	"github.com/0x656b694d/hop/seqdiag"
 	"github.com/0x656b694d/hop/tools"
+	"github.com/0x656b694d/hop/tracing"
+	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type hopHandler struct {
@@ -33,16 +36,32 @@ func getServer(host string, port uint16) *http.Server {
 		IdleTimeout:       10 * time.Minute,
 		MaxHeaderBytes:    1 << 20,
 		ErrorLog:          stdlog.New(log.StandardLogger().Writer(), "http: ", 0),
+		ConnContext:       saveConn,
 	}
 }
 
+// httpHandler is the handler of the cleartext listeners.
+func (cfg *config) httpHandler(client *hopClient, slog *data.ServerLog) http.Handler {
+	var handler http.Handler = &hopHandler{cfg, client, slog}
+	if cfg.http2 {
+		// Cleartext HTTP/2, with prior knowledge or with the upgrade.
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	return handler
+}
+
 func (cfg *config) startHttpServer(client *hopClient, slog *data.ServerLog, quit chan<- int) *http.Server {
 	s := getServer(cfg.localhost, uint16(cfg.port_http))
-	s.Handler = &hopHandler{cfg, client, slog}
+	s.Handler = cfg.httpHandler(client, slog)
+	s.ConnState = trackConn
 
 	go func() {
 		log.Info("Serving HTTP on ", cfg.localhost, ":", cfg.port_http)
-		log.Info(s.ListenAndServe())
+		l, err := cfg.newListener("tcp", s.Addr)
+		if err == nil {
+			err = s.Serve(l)
+		}
+		log.Info(err)
 		quit <- 3
 	}()
 
@@ -50,32 +69,19 @@ func (cfg *config) startHttpServer(client *hopClient, slog *data.ServerLog, quit
 }
 
 func (cfg *config) startHttpsServer(client *hopClient, pool *x509.CertPool, slog *data.ServerLog, quit chan<- int) (*http.Server, error) {
-	stls := getServer(cfg.localhost, uint16(cfg.port_https))
-	stls.Handler = &hopHandler{cfg, client, slog}
-
-	stls.ErrorLog = stdlog.New(log.StandardLogger().Writer(), "tls", 0)
-	stls.TLSConfig = &tls.Config{
-		ClientCAs:    pool,
-		ClientAuth:   tls.VerifyClientCertIfGiven,
-		Certificates: []tls.Certificate{*cfg.getCert("Hop server")},
+	p, err := cfg.tlsPolicy()
+	if err != nil {
+		return nil, err
 	}
-
-	go func() {
-		log.Info("Serving HTTPS on ", cfg.localhost, ":", cfg.port_https)
-		log.Info(stls.ListenAndServeTLS(cfg.certificate, cfg.key))
-		quit <- 4
-	}()
-
-	return stls, nil
+	return cfg.startTLSServer(p, client, pool, slog, quit, 4), nil
 }
 
 func (handler *hopHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if req.Header.Get("Accept") == "text/seqdiag" {
-		handler.cfg.seqdiag = true
-	}
+	requestsInFlight.Inc()
+	defer requestsInFlight.Dec()
 
 	if handler.cfg.verbose {
-		dump, err := httputil.DumpRequest(req, req.ContentLength < 1024)
+		dump, err := dumpRequest(req, req.ContentLength < 1024)
 		if err == nil {
 			log.Debug(string(dump))
 		} else {
@@ -83,21 +89,68 @@ func (handler *hopHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	if handler.cfg.upstream_url != nil {
+		handler.serveProxy(w, req, handler.cfg.upstream_url)
+		return
+	}
+	if handler.cfg.forward_proxy && isProxyRequest(req) {
+		handler.serveProxy(w, req, nil)
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(req) {
+		handler.serveWebSocket(w, req)
+		return
+	}
+
+	slog, code, rheaders := handler.handle(req)
+	w.Header().Add("Server", "hop")
+	for h, v := range rheaders {
+		w.Header().Set(h, v)
+	}
+	bytes := handler.respond(w, req, slog, code)
+	requests.add(slog)
+	access.log(req, slog.Request, bytes)
+	publishRequest(slog.Request, bytes)
+}
+
+// handle executes the commands of the request and calls the next hop. It
+// returns the server log, the response code and the response headers.
+func (handler *hopHandler) handle(req *http.Request) (*data.ServerLog, int, map[string]string) {
 	slog := *handler.log
 
 	slog.Request = &data.RequestLog{
-		Path:   req.URL.RawPath,
+		Path:   redactProxyArgs(req.URL.EscapedPath()),
 		Method: req.Method,
 		From:   req.RemoteAddr,
+		Proto:  req.Proto,
 		Size:   req.ContentLength,
+		Start:  time.Now(),
 	}
 
 	slog.Request.Process = make([]*data.CommandLog, 0)
+	if h, via := proxyHeader(req); h != nil {
+		slog.Request.Via = via.String()
+	}
+
+	// Without an exporter the spans are only created to propagate the trace
+	// context of the caller.
+	var span *tracing.Span
+	if parent := tracing.Extract(req.Header); parent.IsValid() || tracing.Enabled() {
+		span = tracing.Start(req.Method, tracing.KindServer, parent)
+		defer span.Finish()
+		span.SetAttribute("http.request.method", req.Method)
+		span.SetAttribute("url.path", slog.Request.Path)
+		span.SetAttribute("client.address", req.RemoteAddr)
+		span.SetAttribute("server.address", slog.Server)
+		slog.Request.TraceID = span.Context.TraceIDString()
+	}
 
+	code := http.StatusOK
+	var rheaders map[string]string
 	rp, err := makeReq(slog.Request, req)
-	w.Header().Add("Server", "hop")
 	if err != nil {
-		w.WriteHeader(500)
+		code = http.StatusInternalServerError
 		slog.Request.Process = append(slog.Request.Process,
 			&data.CommandLog{
 				Code:   500,
@@ -106,23 +159,51 @@ func (handler *hopHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		)
 	}
 	if rp != nil {
+		rp.span = span
 		if rp.url != nil {
-			log.Debug("sending request to ", rp.url)
+			log.Debug("sending request to ", redactProxyArgs(rp.url.String()))
 			clog := handler.hop(rp)
 			slog.Request.Process = append(slog.Request.Process, clog)
 		}
-		w.WriteHeader(int(rp.code.Set(200)))
-		for h, v := range rp.rheaders {
-			w.Header().Set(h, v)
-		}
+		code = int(rp.code.Set(http.StatusOK))
+		rheaders = rp.rheaders
+	}
+	slog.Request.Code = code
+	span.SetAttribute("http.response.status_code", code)
+	if code >= 500 {
+		span.SetError(http.StatusText(code))
+	}
+	slog.Request.Duration = time.Since(slog.Request.Start)
+	requestsTotal.Inc(methodLabel(req.Method), strconv.Itoa(code))
+	requestDuration.Observe(slog.Request.Duration.Seconds(), methodLabel(req.Method))
+	return &slog, code, rheaders
+}
+
+// respond writes the server log in the format requested by the Accept header:
+// one of the diagram formats, or JSON by default. It returns the number of
+// bytes written.
+func (handler *hopHandler) respond(w http.ResponseWriter, req *http.Request, slog *data.ServerLog, code int) int {
+	var b []byte
+	var err error
+	contentType := "application/json; charset=utf-8"
+	if f := seqdiag.Negotiate(req.Header.Get("Accept")); f != nil {
+		var d string
+		d, err = f.Translate(slog)
+		b = []byte(d)
+		contentType = f.MediaType + "; charset=utf-8"
+	} else {
+		b, err = json.MarshalIndent(slog, "", "  ")
 	}
-	b, err := json.MarshalIndent(slog, "", "  ")
 	if err != nil {
 		log.Error("Error marshalling response: ", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-	} else {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(b)
-		log.Debug(string(b))
+		return 0
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", contentType)
 	}
+	w.WriteHeader(code)
+	n, _ := w.Write(b)
+	log.Debug(string(b))
+	return n
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// requestStop asks main to stop the servers after draining for the given
+// duration. A shutdown already requested is not delayed.
+func requestStop(drain time.Duration) {
+	select {
+	case stop <- drain:
+	default:
+	}
+}
+
+// shutdown stops the servers gracefully: it fails the readiness probe, keeps
+// serving for the delay, so that the load balancers remove the endpoint, and
+// then waits for the in-flight requests. The admin server, which serves the
+// probes, is stopped last.
+func (cfg *config) shutdown(delay time.Duration, servers []*http.Server, admin *http.Server) error {
+	if cfg.shutdown_fail_ready {
+		log.Info("Shutdown: failing readiness")
+		readiness.set(false, 0)
+	}
+	if delay > 0 {
+		log.Infof("Shutdown: draining for %s", delay)
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.shutdown_timeout)
+	defer cancel()
+	log.Infof("Shutdown: stopping the servers, waiting up to %s for the in-flight requests", cfg.shutdown_timeout)
+	var wg sync.WaitGroup
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		if s == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(s *http.Server) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				log.Errorf("Shutdown: server %s: %v", s.Addr, err)
+				s.Close()
+				errs <- err
+			}
+		}(s)
+	}
+	wg.Wait()
+	close(errs)
+
+	if admin != nil {
+		log.Info("Shutdown: stopping the admin server")
+		events.close()
+		if err := admin.Shutdown(ctx); err != nil {
+			log.Error("Shutdown: admin server: ", err)
+			admin.Close()
+		}
+	}
+	log.Info("Shutdown: done")
+	return <-errs
+}
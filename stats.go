@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	started   = time.Now()
+	openConns atomic.Int64
+)
+
+type heapStats struct {
+	Alloc    uint64 `json:"alloc"`
+	Sys      uint64 `json:"sys"`
+	Idle     uint64 `json:"idle"`
+	InUse    uint64 `json:"in-use"`
+	Released uint64 `json:"released"`
+	Objects  uint64 `json:"objects"`
+}
+
+type gcStats struct {
+	Num        uint32        `json:"num"`
+	Forced     uint32        `json:"forced"`
+	PauseTotal time.Duration `json:"pause-total"`
+	LastPause  time.Duration `json:"last-pause"`
+	Last       time.Time     `json:"last"`
+	NextTarget uint64        `json:"next-target"`
+	CPUFrac    float64       `json:"cpu-fraction"`
+}
+
+// runtimeStats is the state of the hop process, served on /stats.
+type runtimeStats struct {
+	Uptime          time.Duration `json:"uptime"`
+	Goroutines      int           `json:"goroutines"`
+	OpenConnections int64         `json:"open-connections"`
+	Heap            heapStats     `json:"heap"`
+	GC              gcStats       `json:"gc"`
+}
+
+// trackConn counts the open connections of the hop servers.
+func trackConn(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		openConns.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		openConns.Add(-1)
+	}
+}
+
+func getRuntimeStats() *runtimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s := &runtimeStats{
+		Uptime:          time.Since(started),
+		Goroutines:      runtime.NumGoroutine(),
+		OpenConnections: openConns.Load(),
+		Heap: heapStats{
+			Alloc:    m.HeapAlloc,
+			Sys:      m.HeapSys,
+			Idle:     m.HeapIdle,
+			InUse:    m.HeapInuse,
+			Released: m.HeapReleased,
+			Objects:  m.HeapObjects,
+		},
+		GC: gcStats{
+			Num:        m.NumGC,
+			Forced:     m.NumForcedGC,
+			PauseTotal: time.Duration(m.PauseTotalNs),
+			NextTarget: m.NextGC,
+			CPUFrac:    m.GCCPUFraction,
+		},
+	}
+	if m.NumGC > 0 {
+		s.GC.LastPause = time.Duration(m.PauseNs[(m.NumGC+255)%256])
+		s.GC.Last = time.Unix(0, int64(m.LastGC))
+	}
+	return s
+}
+
+func serveStats(w http.ResponseWriter, req *http.Request) {
+	b, err := json.MarshalIndent(getRuntimeStats(), "", "  ")
+	if err != nil {
+		log.Error("Error marshalling stats: ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
+
+// serveLogLevel returns the logging level, and changes it on PUT or POST with
+// the level query parameter, e.g. /loglevel?level=debug.
+func serveLogLevel(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut, http.MethodPost:
+		level, err := log.ParseLevel(req.URL.Query().Get("level"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if previous := log.GetLevel(); previous != level {
+			log.SetLevel(level)
+			log.Warnf("Changed the logging level from %s to %s", previous, level)
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, log.GetLevel())
+}
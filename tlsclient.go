@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/0x656b694d/hop/tlstools"
+	"github.com/0x656b694d/hop/tools"
+)
+
+// tlsKey is the request context key of the TLS settings of the following
+// hop.
+type tlsKey struct{}
+
+// tlsParams override the TLS client settings for the following hop, by the
+// -sni, -tlsver, -ciphers, -curves, -alpn and -insecure commands.
+type tlsParams struct {
+	sni      string
+	min, max uint16
+	ciphers  []uint16
+	curves   []tls.CurveID
+	alpn     []string
+	insecure *bool
+}
+
+func withTLS(req *http.Request, p *tlsParams) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), tlsKey{}, p))
+}
+
+// apply returns a copy of the configuration with the settings.
+func (p *tlsParams) apply(cfg *tls.Config) *tls.Config {
+	cfg = cfg.Clone()
+	if p.sni != "" {
+		cfg.ServerName = p.sni
+	}
+	if p.min != 0 {
+		cfg.MinVersion = p.min
+	}
+	if p.max != 0 {
+		cfg.MaxVersion = p.max
+	}
+	if p.ciphers != nil {
+		cfg.CipherSuites = p.ciphers
+	}
+	if p.curves != nil {
+		cfg.CurvePreferences = p.curves
+	}
+	if p.alpn != nil {
+		cfg.NextProtos = p.alpn
+	}
+	if p.insecure != nil {
+		cfg.InsecureSkipVerify = *p.insecure
+	}
+	return cfg
+}
+
+// tlsStep executes the TLS client commands.
+func tlsStep(r *tools.ArrLog, rp *reqParams, command, args string) error {
+	if rp.tls == nil {
+		rp.tls = &tlsParams{}
+	}
+	p := rp.tls
+	var err error
+	switch command {
+	case "-sni":
+		p.sni = args
+		r.Appendf("Will send the server name %s", args)
+	case "-tlsver":
+		if p.min, p.max, err = tlstools.ParseVersions(args); err != nil {
+			return err
+		}
+		r.Appendf("Will use TLS versions %s", args)
+	case "-ciphers":
+		if p.ciphers, err = tlstools.ParseCipherSuites(args); err != nil {
+			return err
+		}
+		names := make([]string, len(p.ciphers))
+		for i, c := range p.ciphers {
+			names[i] = tls.CipherSuiteName(c)
+		}
+		r.Appendf("Will offer the cipher suites %s", strings.Join(names, ", "))
+	case "-curves":
+		if p.curves, err = tlstools.ParseCurves(args); err != nil {
+			return err
+		}
+		r.Appendf("Will offer the curves %v", p.curves)
+	case "-alpn":
+		if args, err = url.PathUnescape(args); err != nil {
+			return err
+		}
+		p.alpn = strings.Split(args, ",")
+		r.Appendf("Will offer the protocols %s", strings.Join(p.alpn, ", "))
+	case "-insecure":
+		insecure := true
+		if args != "" {
+			if insecure, err = strconv.ParseBool(args); err != nil {
+				return err
+			}
+		}
+		p.insecure = &insecure
+		r.Appendf("Will skip the server certificate verification: %t", insecure)
+	default:
+		return fmt.Errorf("unexpected TLS command %s", command)
+	}
+	return nil
+}
+
+// offersHTTP2 tells if the offered protocols include HTTP/2.
+func (p *tlsParams) offersHTTP2() bool {
+	return slices.Contains(p.alpn, "h2")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/0x656b694d/hop/data"
+	"github.com/0x656b694d/hop/tlstools"
+	log "github.com/sirupsen/logrus"
+)
+
+// tlsPolicy is the TLS policy of an HTTPS server.
+type tlsPolicy struct {
+	port       uint
+	min, max   uint16
+	ciphers    []uint16
+	curves     []tls.CurveID
+	clientAuth tls.ClientAuthType
+}
+
+// set sets the setting of the key: min, max, ciphers, curves or client-auth.
+func (p *tlsPolicy) set(key, value string) error {
+	var err error
+	switch key {
+	case "min":
+		p.min, err = tlstools.ParseVersion(value)
+	case "max":
+		p.max, err = tlstools.ParseVersion(value)
+	case "ciphers":
+		p.ciphers, err = tlstools.ParseCipherSuites(value)
+	case "curves":
+		p.curves, err = tlstools.ParseCurves(value)
+	case "client-auth":
+		p.clientAuth, err = tlstools.ParseClientAuth(value)
+	default:
+		err = fmt.Errorf("unexpected TLS setting %q", key)
+	}
+	return err
+}
+
+func (p *tlsPolicy) String() string {
+	version := func(v uint16) string {
+		if v == 0 {
+			return "default"
+		}
+		return tls.VersionName(v)
+	}
+	return fmt.Sprintf("versions %s-%s, client auth %s", version(p.min), version(p.max), p.clientAuth)
+}
+
+// tlsPolicy makes the policy of the HTTPS port from the flags.
+func (cfg *config) tlsPolicy() (*tlsPolicy, error) {
+	p := &tlsPolicy{port: cfg.port_https}
+	for _, kv := range [][2]string{
+		{"min", cfg.tls_min_version},
+		{"max", cfg.tls_max_version},
+		{"ciphers", cfg.tls_ciphers},
+		{"curves", cfg.tls_curves},
+		{"client-auth", cfg.client_auth},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+		if err := p.set(kv[0], kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	if p.max != 0 && p.min > p.max {
+		return nil, fmt.Errorf("TLS min version %s is above the max version %s", tls.VersionName(p.min), tls.VersionName(p.max))
+	}
+	return p, nil
+}
+
+// parseTLSListener parses an additional TLS listener, like
+// "port=8443,min=1.0,max=1.1,ciphers=A:B,curves=P256,client-auth=require".
+// The missing settings are the ones of the flags.
+func (cfg *config) parseTLSListener(s string) (*tlsPolicy, error) {
+	p, err := cfg.tlsPolicy()
+	if err != nil {
+		return nil, err
+	}
+	p.port = 0
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("missing value of %q", part)
+		}
+		if kv[0] == "port" {
+			port, err := strconv.ParseUint(kv[1], 10, 16)
+			if err != nil {
+				return nil, err
+			}
+			p.port = uint(port)
+		} else if err := p.set(kv[0], kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	if p.port == 0 {
+		return nil, fmt.Errorf("missing port in %q", s)
+	}
+	if p.max != 0 && p.min > p.max {
+		return nil, fmt.Errorf("TLS min version %s is above the max version %s in %q", tls.VersionName(p.min), tls.VersionName(p.max), s)
+	}
+	return p, nil
+}
+
+// serveHTTP2 tells if the server with the policy serves HTTP/2, which
+// requires TLS 1.2 at least.
+func (cfg *config) serveHTTP2(p *tlsPolicy) bool {
+	return cfg.http2 && (p.max == 0 || p.max >= tls.VersionTLS12)
+}
+
+// tlsConfig makes the server TLS configuration with the policy.
+func (cfg *config) tlsConfig(p *tlsPolicy, pool *x509.CertPool) *tls.Config {
+	c := &tls.Config{
+		ClientCAs:        pool,
+		ClientAuth:       p.clientAuth,
+		Certificates:     []tls.Certificate{*cfg.getCert("Hop server")},
+		NextProtos:       []string{"h2", "http/1.1"},
+		MinVersion:       p.min,
+		MaxVersion:       p.max,
+		CipherSuites:     p.ciphers,
+		CurvePreferences: p.curves,
+	}
+	if !cfg.serveHTTP2(p) {
+		c.NextProtos = []string{"http/1.1"}
+	}
+	return c
+}
+
+// startTLSServer serves HTTPS with the policy. It sends n to quit when
+// stopped.
+func (cfg *config) startTLSServer(p *tlsPolicy, client *hopClient, pool *x509.CertPool, slog *data.ServerLog, quit chan<- int, n int) *http.Server {
+	stls := getServer(cfg.localhost, uint16(p.port))
+	stls.Handler = &hopHandler{cfg, client, slog}
+	stls.ConnState = trackConn
+
+	stls.ErrorLog = stdlog.New(log.StandardLogger().Writer(), "tls", 0)
+	stls.TLSConfig = cfg.tlsConfig(p, pool)
+	if !cfg.serveHTTP2(p) {
+		stls.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	go func() {
+		log.Info("Serving HTTPS on ", cfg.localhost, ":", p.port, " with ", p)
+		l, err := cfg.newListener("tcp", stls.Addr)
+		if err == nil {
+			err = stls.ServeTLS(l, cfg.certificate, cfg.key)
+		}
+		log.Info(err)
+		quit <- n
+	}()
+
+	return stls
+}
+
+// startTLSListeners serves HTTPS on the additional TLS listeners, each with
+// its policy.
+func (cfg *config) startTLSListeners(client *hopClient, pool *x509.CertPool, slog *data.ServerLog, quit chan<- int) ([]*http.Server, error) {
+	policies := make([]*tlsPolicy, 0, len(cfg.tls_listen))
+	for _, s := range cfg.tls_listen {
+		p, err := cfg.parseTLSListener(s)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	servers := make([]*http.Server, 0, len(policies))
+	for _, p := range policies {
+		servers = append(servers, cfg.startTLSServer(p, client, pool, slog, quit, 9))
+	}
+	return servers, nil
+}
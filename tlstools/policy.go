@@ -0,0 +1,134 @@
+package tlstools
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/0x656b694d/hop/tools"
+)
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var curves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// ParseVersion parses a TLS version, like 1.2 or TLS1.2.
+func ParseVersion(s string) (uint16, error) {
+	v, ok := versions[strings.TrimPrefix(strings.ToUpper(s), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", s)
+	}
+	return v, nil
+}
+
+// ParseVersions parses a range of TLS versions, like 1.2-1.3, 1.2- or -1.2,
+// or a single version. A missing bound is 0.
+func ParseVersions(s string) (min, max uint16, err error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+	if lo != "" {
+		if min, err = ParseVersion(lo); err != nil {
+			return
+		}
+	}
+	if hi != "" {
+		if max, err = ParseVersion(hi); err != nil {
+			return
+		}
+	}
+	if min == 0 && max == 0 {
+		err = fmt.Errorf("empty TLS version range %q", s)
+	} else if max != 0 && min > max {
+		err = fmt.Errorf("bad TLS version range %q", s)
+	}
+	return
+}
+
+// ParseCipherSuites parses the cipher suites, separated by commas or colons,
+// by their names, like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, or by their
+// numbers, like 0xc02f. The insecure suites are accepted too.
+func ParseCipherSuites(s string) ([]uint16, error) {
+	names := map[string]uint16{}
+	for _, c := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		names[c.Name] = c.ID
+	}
+	ids := []uint16{}
+	for _, name := range strings.FieldsFunc(s, isListSeparator) {
+		id, ok := names[strings.ToUpper(name)]
+		if !ok {
+			n, err := strconv.ParseUint(name, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("unknown cipher suite %q", name)
+			}
+			id = uint16(n)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no cipher suites in %q", s)
+	}
+	return ids, nil
+}
+
+// ParseCurves parses the curves, separated by commas or colons, like
+// X25519,P256 (or P-256).
+func ParseCurves(s string) ([]tls.CurveID, error) {
+	ids := []tls.CurveID{}
+	for _, name := range strings.FieldsFunc(s, isListSeparator) {
+		id, ok := curves[strings.ReplaceAll(strings.ToUpper(name), "-", "")]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no curves in %q", s)
+	}
+	return ids, nil
+}
+
+func isListSeparator(r rune) bool {
+	return r == ',' || r == ':'
+}
+
+// AppendConnectionState appends a one-line summary of the negotiated
+// parameters.
+func AppendConnectionState(r *tools.ArrLog, t *tls.ConnectionState) {
+	if t == nil {
+		r.Append("No TLS")
+		return
+	}
+	r.Appendf("Negotiated %s, %s, ALPN %q, server name %q",
+		tls.VersionName(t.Version), tls.CipherSuiteName(t.CipherSuite), t.NegotiatedProtocol, t.ServerName)
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+// ParseClientAuth parses a client authentication mode: none, request,
+// require, verify-if-given or require-and-verify.
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	a, ok := clientAuthTypes[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown client authentication mode %q", s)
+	}
+	return a, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/0x656b694d/hop/data"
+)
+
+// timingTrace measures the phases of a downstream call. The callbacks may be
+// called from the transport goroutines, e.g. for parallel dials, hence the
+// mutex.
+type timingTrace struct {
+	mu      sync.Mutex
+	timing  data.Timing
+	dns     time.Time
+	connect map[string]time.Time
+	tls     time.Time
+	gotConn time.Time
+}
+
+func (t *timingTrace) clientTrace() *httptrace.ClientTrace {
+	t.connect = map[string]time.Time{}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dns = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.DNS += time.Since(t.dns)
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.connect[network+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if err == nil {
+				t.timing.Connect += time.Since(t.connect[network+addr])
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tls = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.TLS += time.Since(t.tls)
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.gotConn = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.FirstByte += time.Since(t.gotConn)
+		},
+	}
+}
+
+// withTrace returns a copy of the request with the client trace attached.
+func withTrace(req *http.Request, trace *httptrace.ClientTrace) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// result returns the timing measured so far.
+func (t *timingTrace) result() *data.Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing := t.timing
+	return &timing
+}
+
+// connTrace records the connection level events of a downstream call for
+// the -trace command.
+type connTrace struct {
+	mu      sync.Mutex
+	trace   data.ConnTrace
+	start   time.Time
+	connect map[string]time.Time
+	tls     time.Time
+}
+
+func (t *connTrace) clientTrace() *httptrace.ClientTrace {
+	t.start = time.Now()
+	t.connect = map[string]time.Time{}
+	return &httptrace.ClientTrace{
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			for _, a := range info.Addrs {
+				t.trace.DNS = append(t.trace.DNS, a.String())
+			}
+			if info.Err != nil {
+				t.trace.DNSError = info.Err.Error()
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.connect[network+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			dial := data.DialTrace{
+				Network:  network,
+				Addr:     addr,
+				Duration: time.Since(t.connect[network+addr]),
+			}
+			if err != nil {
+				dial.Error = err.Error()
+			}
+			t.trace.Dials = append(t.trace.Dials, dial)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.trace.Conns = append(t.trace.Conns, data.ConnInfo{
+				Local:    info.Conn.LocalAddr().String(),
+				Remote:   info.Conn.RemoteAddr().String(),
+				Reused:   info.Reused,
+				WasIdle:  info.WasIdle,
+				IdleTime: info.IdleTime,
+			})
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tls = time.Now()
+		},
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.trace.TLS = &data.TLSTrace{
+				Version:    tls.VersionName(cs.Version),
+				Cipher:     tls.CipherSuiteName(cs.CipherSuite),
+				Protocol:   cs.NegotiatedProtocol,
+				ServerName: cs.ServerName,
+				Resumed:    cs.DidResume,
+				Duration:   time.Since(t.tls),
+			}
+			if err != nil {
+				t.trace.TLS.Error = err.Error()
+			}
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.trace.FirstByte = time.Since(t.start)
+		},
+		Got100Continue: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.trace.Got100++
+		},
+	}
+}
+
+// result returns the trace recorded so far.
+func (t *connTrace) result() *data.ConnTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace := t.trace
+	return &trace
+}
@@ -0,0 +1,234 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	batchSize     = 64
+	batchInterval = time.Second
+	queueSize     = 1024
+)
+
+// otlpExporter sends the spans in batches, encoded as OTLP/HTTP JSON, to a
+// collector or to a JSON lines file.
+type otlpExporter struct {
+	resource map[string]any
+	send     func([]byte) error
+	closer   io.Closer
+
+	mu     sync.RWMutex
+	closed bool
+	spans  chan *Span
+	done   chan struct{}
+}
+
+// NewOTLPExporter creates an exporter which posts the spans to the collector
+// endpoint, e.g. http://collector:4318/v1/traces.
+func NewOTLPExporter(endpoint string, resource map[string]any) Exporter {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return newOTLPExporter(resource, nil, func(b []byte) error {
+		res, err := client.Post(endpoint, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		io.Copy(io.Discard, res.Body)
+		if res.StatusCode/100 != 2 {
+			return fmt.Errorf("collector %s responded with %s", endpoint, res.Status)
+		}
+		return nil
+	})
+}
+
+// NewFileExporter creates an exporter which appends the spans to the file,
+// one OTLP JSON request per line.
+func NewFileExporter(filename string, resource map[string]any) (Exporter, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open spans file: %w", err)
+	}
+	return newOTLPExporter(resource, f, func(b []byte) error {
+		_, err := f.Write(append(b, '\n'))
+		return err
+	}), nil
+}
+
+func newOTLPExporter(resource map[string]any, closer io.Closer, send func([]byte) error) *otlpExporter {
+	e := &otlpExporter{
+		resource: resource,
+		send:     send,
+		closer:   closer,
+		spans:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *otlpExporter) Export(s *Span) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.spans <- s:
+	default:
+		log.Warn("Spans queue is full, dropping span ", s.Name)
+	}
+}
+
+// Close flushes the queued spans.
+func (e *otlpExporter) Close() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.spans)
+	}
+	e.mu.Unlock()
+	<-e.done
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if b, err := Encode(e.resource, batch); err != nil {
+			log.Error("Failed to encode spans: ", err)
+		} else if err = e.send(b); err != nil {
+			log.Error("Failed to export spans: ", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case s, ok := <-e.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+type otlpValue map[string]any
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	TraceState        string          `json:"traceState,omitempty"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Flags             uint32          `json:"flags,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// Encode returns the OTLP JSON export request with the spans.
+func Encode(resource map[string]any, spans []*Span) ([]byte, error) {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.Context.SpanID[:]),
+			TraceState:        s.Context.State,
+			Flags:             uint32(s.Context.Flags),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+		}
+		if s.Parent != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.Parent[:])
+		}
+		if s.Error != "" {
+			o.Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		s.mu.Unlock()
+		encoded = append(encoded, o)
+	}
+	return json.Marshal(map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{"attributes": attributes(resource)},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": "hop"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	})
+}
+
+func attributes(m map[string]any) []otlpAttribute {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]otlpAttribute, 0, len(m))
+	for _, k := range keys {
+		var v otlpValue
+		switch value := m[k].(type) {
+		case string:
+			v = otlpValue{"stringValue": value}
+		case bool:
+			v = otlpValue{"boolValue": value}
+		case int:
+			v = otlpValue{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = otlpValue{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = otlpValue{"doubleValue": value}
+		default:
+			v = otlpValue{"stringValue": fmt.Sprint(value)}
+		}
+		attrs = append(attrs, otlpAttribute{k, v})
+	}
+	return attrs
+}
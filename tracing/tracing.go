@@ -0,0 +1,207 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+
+	flagSampled = 0x01
+)
+
+// SpanContext is the W3C trace context.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	State   string
+}
+
+// IsValid tells whether the trace and span identifiers are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Sampled tells whether the caller records the trace.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+// TraceIDString returns the trace identifier in hex.
+func (sc SpanContext) TraceIDString() string {
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// Traceparent formats the traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), sc.Flags)
+}
+
+// Inject sets the trace context headers.
+func (sc SpanContext) Inject(h http.Header) {
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.State != "" {
+		h.Set(TracestateHeader, sc.State)
+	}
+}
+
+// Extract reads the trace context headers. The result is invalid if there is
+// no traceparent header or if it cannot be parsed.
+func Extract(h http.Header) SpanContext {
+	sc, err := Parse(h.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}
+	}
+	sc.State = strings.Join(h.Values(TracestateHeader), ",")
+	return sc
+}
+
+// Parse parses the traceparent header value.
+func Parse(traceparent string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("bad traceparent: %q", traceparent)
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return sc, fmt.Errorf("bad traceparent version: %q", traceparent)
+	}
+	if err := decode(sc.TraceID[:], parts[1]); err != nil {
+		return sc, fmt.Errorf("bad trace id: %w", err)
+	}
+	if err := decode(sc.SpanID[:], parts[2]); err != nil {
+		return sc, fmt.Errorf("bad parent id: %w", err)
+	}
+	var flags [1]byte
+	if err := decode(flags[:], parts[3]); err != nil {
+		return sc, fmt.Errorf("bad trace flags: %w", err)
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent: %q", traceparent)
+	}
+	return sc, nil
+}
+
+func decode(dst []byte, s string) error {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return fmt.Errorf("expected %d lowercase hex digits: %q", 2*len(dst), s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+type SpanKind int
+
+// The values are the ones of the OTLP protocol.
+const (
+	KindServer SpanKind = 2
+	KindClient SpanKind = 3
+)
+
+// Span is an operation of the trace.
+type Span struct {
+	Context    SpanContext
+	Parent     [8]byte
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]any
+	Error      string
+
+	mu sync.Mutex
+}
+
+// Start begins a new span, which is a child of the parent if the parent
+// context is valid, or the root of a new trace otherwise.
+func Start(name string, kind SpanKind, parent SpanContext) *Span {
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: map[string]any{},
+	}
+	if parent.IsValid() {
+		s.Context.TraceID = parent.TraceID
+		s.Context.Flags = parent.Flags
+		s.Context.State = parent.State
+		s.Parent = parent.SpanID
+	} else {
+		rand.Read(s.Context.TraceID[:])
+		s.Context.Flags = flagSampled
+	}
+	rand.Read(s.Context.SpanID[:])
+	return s
+}
+
+// The methods below accept a nil span, which is a span that isn't recorded.
+
+// SetAttribute sets an attribute of the span. The value is a string, an
+// integer, a float or a boolean.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = msg
+}
+
+// Finish ends the span and hands it to the exporter, if the trace is sampled.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.End = time.Now()
+	s.mu.Unlock()
+	if e := exporter; e != nil && s.Context.Sampled() {
+		e.Export(s)
+	}
+}
+
+// Exporter sends the finished spans somewhere.
+type Exporter interface {
+	Export(*Span)
+	Close() error
+}
+
+var exporter Exporter
+
+// SetExporter sets the exporter of the finished spans.
+func SetExporter(e Exporter) {
+	exporter = e
+}
+
+// Enabled tells whether the spans are exported.
+func Enabled() bool {
+	return exporter != nil
+}
+
+// Shutdown flushes the spans and closes the exporter.
+func Shutdown() error {
+	if exporter == nil {
+		return nil
+	}
+	return exporter.Close()
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]struct {
+		traceparent string
+		valid       bool
+	}{
+		"valid":            {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		"future version":   {"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what", true},
+		"version 00 extra": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what", false},
+		"version ff":       {"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		"uppercase":        {"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		"zero trace id":    {"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		"zero span id":     {"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		"short":            {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		"empty":            {"", false},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			sc, err := Parse(c.traceparent)
+			if c.valid {
+				assert.NoError(t, err)
+				assert.True(t, sc.IsValid())
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	h := http.Header{}
+	h.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Add("tracestate", "a=1")
+	h.Add("tracestate", "b=2")
+	parent := Extract(h)
+	require.True(t, parent.IsValid())
+	assert.True(t, parent.Sampled())
+	assert.Equal(t, "a=1,b=2", parent.State)
+
+	span := Start("GET", KindClient, parent)
+	assert.Equal(t, parent.TraceID, span.Context.TraceID)
+	assert.Equal(t, parent.SpanID, span.Parent)
+	assert.NotEqual(t, parent.SpanID, span.Context.SpanID)
+
+	out := http.Header{}
+	span.Context.Inject(out)
+	child, err := Parse(out.Get("traceparent"))
+	assert.NoError(t, err)
+	assert.Equal(t, span.Context.SpanID, child.SpanID)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", child.TraceIDString())
+	assert.Equal(t, "a=1,b=2", out.Get("tracestate"))
+
+	root := Start("GET", KindServer, Extract(http.Header{}))
+	assert.True(t, root.Context.IsValid())
+	assert.True(t, root.Context.Sampled())
+	assert.Equal(t, [8]byte{}, root.Parent)
+}
+
+func TestEncode(t *testing.T) {
+	parent, err := Parse("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	span := Start("GET", KindServer, parent)
+	span.Start = time.Unix(1, 0)
+	span.SetAttribute("http.response.status_code", 500)
+	span.SetAttribute("url.path", "/x")
+	span.SetError("Internal Server Error")
+	span.End = time.Unix(2, 0)
+
+	b, err := Encode(map[string]any{"service.name": "hop"}, []*Span{span})
+	require.NoError(t, err)
+
+	var decoded struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpAttribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	require.Len(t, decoded.ResourceSpans, 1)
+	assert.Equal(t, []otlpAttribute{{"service.name", otlpValue{"stringValue": "hop"}}}, decoded.ResourceSpans[0].Resource.Attributes)
+	require.Len(t, decoded.ResourceSpans[0].ScopeSpans, 1)
+	spans := decoded.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 1)
+	s := spans[0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", s.ParentSpanID)
+	assert.Len(t, s.SpanID, 16)
+	assert.Equal(t, KindServer, s.Kind)
+	assert.Equal(t, "1000000000", s.StartTimeUnixNano)
+	assert.Equal(t, "2000000000", s.EndTimeUnixNano)
+	assert.Equal(t, otlpStatus{Code: 2, Message: "Internal Server Error"}, s.Status)
+	assert.Equal(t, []otlpAttribute{
+		{"http.response.status_code", otlpValue{"intValue": "500"}},
+		{"url.path", otlpValue{"stringValue": "/x"}},
+	}, s.Attributes)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/0x656b694d/hop/data"
+	log "github.com/sirupsen/logrus"
+)
+
+// socketKey is the request context key of the Unix socket to dial.
+type socketKey struct{}
+
+func withSocket(req *http.Request, socket string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), socketKey{}, socket))
+}
+
+// dialContext dials the Unix socket of the request context, if any, instead
+// of the address, and sends the PROXY protocol header of the context.
+func dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if socket, ok := ctx.Value(socketKey{}).(string); ok {
+			network, addr = "unix", socket
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := sendProxyHeader(ctx, conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// unixHost makes a host name for the socket, so that the connections to
+// different sockets are not pooled together.
+func unixHost(socket string) string {
+	h := fnv.New32a()
+	h.Write([]byte(socket))
+	return fmt.Sprintf("unix-%08x", h.Sum32())
+}
+
+// parseUnixTarget parses a Unix socket target, like unix:/path.sock, followed
+// by the path of the next hop, or like unix:/path.sock:/rest.
+func parseUnixTarget(addr, path string) (string, *url.URL, error) {
+	addr, err := url.PathUnescape(addr)
+	if err != nil {
+		return "", nil, err
+	}
+	socket, rest, _ := strings.Cut(strings.TrimPrefix(addr, "unix:"), ":")
+	socket = strings.TrimSuffix(socket, "/")
+	if socket == "" {
+		return "", nil, fmt.Errorf("missing socket path in %s", addr)
+	}
+	rest = strings.TrimPrefix(rest, "/")
+	if rest != "" && path != "" {
+		rest += "/"
+	}
+	u, err := url.Parse(fmt.Sprintf("http://%s/%s%s", unixHost(socket), rest, path))
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot call %s: %s", addr, err.Error())
+	}
+	return socket, u, nil
+}
+
+// unixURL shows the target as it was given.
+func unixURL(socket string, u *url.URL) string {
+	return "unix:" + socket + ":" + u.RequestURI()
+}
+
+// startListeners serves HTTP on the additional listeners, like
+// unix:/path.sock.
+func (cfg *config) startListeners(client *hopClient, slog *data.ServerLog, quit chan<- int) ([]*http.Server, error) {
+	servers := []*http.Server{}
+	for _, listen := range cfg.listen {
+		socket, ok := strings.CutPrefix(listen, "unix:")
+		if !ok {
+			return servers, fmt.Errorf("unsupported listener %q", listen)
+		}
+		if fi, err := os.Stat(socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			log.Debug("Removing the stale socket ", socket)
+			os.Remove(socket)
+		}
+		l, err := cfg.newListener("unix", socket)
+		if err != nil {
+			return servers, err
+		}
+		s := getServer("", 0)
+		s.Addr = listen
+		s.Handler = cfg.httpHandler(client, slog)
+		s.ConnState = trackConn
+		go func() {
+			log.Info("Serving HTTP on ", listen)
+			log.Info(s.Serve(l))
+			quit <- 8
+		}()
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0x656b694d/hop/data"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// wsParams are the arguments of the -ws command.
+type wsParams struct {
+	n        int
+	interval time.Duration
+	msg      string
+}
+
+// parseWSArgs parses the -ws command arguments, e.g. "n=10,interval=1s".
+func parseWSArgs(args string) (*wsParams, error) {
+	p := &wsParams{n: 1}
+	if args == "" {
+		return p, nil
+	}
+	for _, a := range strings.Split(args, ",") {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("missing value of %q", a)
+		}
+		var err error
+		switch kv[0] {
+		case "n":
+			if p.n, err = strconv.Atoi(kv[1]); err == nil && p.n < 1 {
+				err = fmt.Errorf("bad number of messages %d", p.n)
+			}
+		case "interval":
+			p.interval, err = parseDuration(kv[1])
+		case "msg":
+			p.msg, err = url.PathUnescape(kv[1])
+		default:
+			err = fmt.Errorf("unexpected argument %q", a)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// isCommandPath tells if a text message is a command path rather than a
+// message to echo.
+func isCommandPath(msg string) bool {
+	return strings.HasPrefix(msg, "/") || strings.HasPrefix(msg, "-")
+}
+
+// serveWebSocket runs a session on an upgraded connection: a text message
+// with a command path, like /-wait:100/hop2, is handled as a request and
+// answered with the server log. Other messages are echoed.
+func (handler *hopHandler) serveWebSocket(w http.ResponseWriter, req *http.Request) {
+	conn, err := upgrader.Upgrade(w, req, http.Header{"Server": {"hop"}})
+	if err != nil {
+		log.Error("WebSocket upgrade failed: ", err)
+		return
+	}
+	defer conn.Close()
+	log.Info("WebSocket session with ", req.RemoteAddr)
+	for {
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Error("WebSocket session with ", req.RemoteAddr, ": ", err)
+			}
+			return
+		}
+		if mt == websocket.TextMessage && isCommandPath(string(msg)) {
+			msg = handler.wsCommand(req, string(msg))
+		}
+		if err := conn.WriteMessage(mt, msg); err != nil {
+			log.Error("WebSocket session with ", req.RemoteAddr, ": ", err)
+			return
+		}
+	}
+}
+
+// wsCommand handles the command path of a message as a request with the
+// headers of the upgrade request.
+func (handler *hopHandler) wsCommand(upgrade *http.Request, path string) []byte {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u, err := url.ParseRequestURI(path)
+	if err != nil {
+		return []byte(fmt.Sprintf("Bad command: %s", err))
+	}
+	req := upgrade.Clone(upgrade.Context())
+	req.URL = u
+	req.RequestURI = path
+	req.Body = http.NoBody
+	req.ContentLength = 0
+
+	slog, _, _ := handler.handle(req)
+	b, err := json.Marshal(slog)
+	if err != nil {
+		log.Error("Error marshalling response: ", err)
+		return []byte(err.Error())
+	}
+	requests.add(slog)
+	access.log(req, slog.Request, len(b))
+	publishRequest(slog.Request, len(b))
+	return b
+}
+
+// hopWS opens a WebSocket to the next hop and sends the messages, waiting
+// for the reply to each of them. By default the message is the path of the
+// next hop, so that each message makes the next hop execute it.
+func (handler *hopHandler) hopWS(clog *data.CommandLog, params *reqParams) {
+	r := &clog.Output
+	u := *params.url
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	msg := params.ws.msg
+	if msg == "" {
+		msg = params.url.RequestURI()
+	}
+	header := http.Header{}
+	for h, v := range params.headers {
+		header.Set(h, v)
+	}
+	// The WebSocket handshake is HTTP/1.1 only.
+	tlsConfig := handler.client.tlsConfig().Clone()
+	tlsConfig.NextProtos = nil
+	if params.tls != nil {
+		tlsConfig = params.tls.apply(tlsConfig)
+	}
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:  tlsConfig,
+		NetDialContext:   dialContext(&net.Dialer{}),
+	}
+	ctx := context.Background()
+	dialer.Proxy = handler.cfg.proxy
+
+	clog.Method = http.MethodGet
+	clog.Url = redactProxyArgs(u.String())
+	if params.proxyHeader != nil {
+		ctx = context.WithValue(ctx, proxyHeaderKey{}, params.proxyHeader)
+	}
+	if params.socket != "" {
+		ctx = context.WithValue(ctx, socketKey{}, params.socket)
+		clog.Url = redactProxyArgs(unixURL(params.socket, &u))
+	} else if params.noProxy || params.proxy != nil {
+		ctx = context.WithValue(ctx, proxyKey{}, proxyChoice{params.proxy})
+	}
+	if params.span != nil {
+		defer startClientSpan(params.span, clog, http.MethodGet, u.Host, header)()
+	}
+	if params.socket == "" {
+		preq, _ := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if params.noProxy || params.proxy != nil || handler.cfg.proxy_tunneling {
+			logProxy(clog, preq)
+		}
+		// The WebSocket dialer knows only the http and socks5 proxies.
+		if pu, _ := handler.cfg.proxy(preq); pu != nil && pu.Scheme != "http" && pu.Scheme != "socks5" {
+			clog.Error = fmt.Sprintf("-ws cannot go through a %s proxy", pu.Scheme)
+			r.Append(clog.Error)
+			return
+		}
+	}
+	start := time.Now()
+	conn, res, err := dialer.DialContext(ctx, u.String(), header)
+	clog.Latency = time.Since(start)
+	code := "error"
+	if res != nil {
+		clog.Code = uint(res.StatusCode)
+		clog.Proto = res.Proto
+		code = strconv.Itoa(res.StatusCode)
+	}
+	target := targets.label(u.Host)
+	downstreamTotal.Inc(target, code)
+	downstreamDuration.Observe(clog.Latency.Seconds(), target)
+	if err != nil {
+		r.Appendf("WebSocket handshake with %s failed: %s", clog.Url, err)
+		clog.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+	r.Appendf("Connected to %s in %s", clog.Url, clog.Latency)
+
+	var reply []byte
+	for i := 1; i <= params.ws.n; i++ {
+		if i > 1 {
+			time.Sleep(params.ws.interval)
+		}
+		sent := time.Now()
+		if err = conn.WriteMessage(websocket.TextMessage, []byte(msg)); err == nil {
+			_, reply, err = conn.ReadMessage()
+		}
+		if err != nil {
+			r.Appendf("Message %d: %s", i, err)
+			clog.Error = err.Error()
+			break
+		}
+		rtt := time.Since(sent)
+		clog.RTT = append(clog.RTT, rtt)
+		r.Appendf("Message %d: round-trip time %s", i, rtt)
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if len(clog.RTT) > 1 {
+		lo, hi, sum := clog.RTT[0], clog.RTT[0], time.Duration(0)
+		for _, rtt := range clog.RTT {
+			lo, hi = min(lo, rtt), max(hi, rtt)
+			sum += rtt
+		}
+		r.Appendf("Round-trip time min/avg/max: %s/%s/%s", lo, sum/time.Duration(len(clog.RTT)), hi)
+	}
+	if reply == nil {
+		return
+	}
+	if res.Header.Get("Server") != "hop" || json.Unmarshal(reply, &clog.Response) != nil {
+		r.Append(string(reply))
+	}
+}